@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"net"
+)
+
+// logCommon returns the fields shared by the logs.
+func logCommon(qr telescreenLog) *telescreenLogCommon {
+	switch log := qr.(type) {
+	case *QueryLog:
+		return &log.telescreenLogCommon
+	case *ResponseLog:
+		return &log.telescreenLogCommon
+	case *CombinedLog:
+		return &log.telescreenLogCommon
+	case *DoTLog:
+		return &log.telescreenLogCommon
+	default:
+		return nil
+	}
+}
+
+// anonymizeClient replaces the host portion of the client address, i.e., the
+// last 64 bits of IPv6 and the last octet of IPv4, wherever it appears in the
+// log. The host portion is zeroed, or replaced with its HMAC if the key is
+// given, so that queries and responses of a client still share an address.
+func anonymizeClient(qr telescreenLog, key string) {
+	c := logCommon(qr)
+	if c == nil || c.ClientIP == nil {
+		return
+	}
+
+	anonymized := anonymizeIP(c.ClientIP, key)
+	if c.SrcIP.Equal(c.ClientIP) {
+		c.SrcIP = anonymized
+	}
+	if c.DstIP.Equal(c.ClientIP) {
+		c.DstIP = anonymized
+	}
+	c.ClientIP = anonymized
+}
+
+func anonymizeIP(ip net.IP, key string) net.IP {
+	host := 8
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+		host = 1
+	}
+
+	anonymized := make(net.IP, len(ip))
+	copy(anonymized, ip[:len(ip)-host])
+	if key != "" {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write(ip)
+		copy(anonymized[len(ip)-host:], mac.Sum(nil))
+	}
+	return anonymized
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestAnonymizeBeforeExporters(t *testing.T) {
+	defer func(anonymize, sniff bool, key string) {
+		anonymizeFlag, sniffFlag, anonymizeKey = anonymize, sniff, key
+	}(anonymizeFlag, sniffFlag, anonymizeKey)
+	anonymizeFlag, sniffFlag = true, true
+
+	const client = "2001:db8:1:2:aaaa:bbbb:cccc:dddd"
+	for _, key := range []string{"", "secret"} {
+		anonymizeKey = key
+		logs := interceptAll(
+			newDNSPacket(t, nil, client, testServer, 40000, 53, query("www.example.com", layers.DNSTypeAAAA)),
+			newDNSPacket(t, nil, testServer, client, 53, 40000, response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300))),
+		)
+		if len(logs) != 2 {
+			t.Fatalf("key %q: exported %d logs, want 2", key, len(logs))
+		}
+		for _, l := range logs {
+			if s := l.String(); strings.Contains(s, "aaaa:bbbb:cccc:dddd") {
+				t.Errorf("key %q: the client exported in %q", key, s)
+			}
+		}
+		q, r := logCommon(logs[0]).ClientIP, logCommon(logs[1]).ClientIP
+		if q.String() == client || !q.Equal(r) {
+			t.Errorf("key %q: the query from %v and the response to %v, want the same anonymized client", key, q, r)
+		}
+	}
+}
+
+func TestAnonymizeIP(t *testing.T) {
+	tests := []struct {
+		ip, key string
+		want    string
+	}{
+		{"2001:db8:1:2:aaaa:bbbb:cccc:dddd", "", "2001:db8:1:2::"},
+		{"192.0.2.1", "", "192.0.2.0"},
+	}
+	for _, tt := range tests {
+		if got := anonymizeIP(net.ParseIP(tt.ip), tt.key); got.String() != tt.want {
+			t.Errorf("anonymizeIP(%s, %q) = %v, want %s", tt.ip, tt.key, got, tt.want)
+		}
+	}
+
+	// The network portion stays, and the host portion depends on the key
+	ip := net.ParseIP("2001:db8:1:2:aaaa:bbbb:cccc:dddd")
+	a, b := anonymizeIP(ip, "secret"), anonymizeIP(ip, "another")
+	if !(&net.IPNet{IP: net.ParseIP("2001:db8:1:2::"), Mask: net.CIDRMask(64, 128)}).Contains(a) || a.Equal(b) || a.Equal(ip) {
+		t.Errorf("anonymized %v by a key and %v by another", a, b)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"container/list"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Caches in use, reported by the metrics along with the counts of those closed
+var (
+	cachesMu     sync.Mutex
+	caches       []*boundedCache
+	closedCaches = map[string]cacheStats{}
+)
+
+type cacheEntry struct {
+	key   string
+	value interface{}
+	at    time.Time // Last put
+}
+
+// boundedCache holds at most a number of entries, evicting the least recently
+// used one when full, so that a flood of unique names cannot exhaust memory.
+// Entries not put again within the TTL expire, unless it is 0. Every in-memory
+// table keyed by what is captured should be built on it.
+type boundedCache struct {
+	mu          sync.Mutex
+	name        string // Label of the metrics, shared by the caches of a table
+	size        int
+	ttl         time.Duration
+	entries     map[string]*list.Element
+	order       *list.List // Most recently used at front
+	evictions   uint64     // Entries evicted to make room
+	expirations uint64     // Entries expired by the TTL
+
+	// Called with the cache locked for each entry evicted or expired, e.g., to
+	// count what is given up, if not nil
+	onEvict func(key string, value interface{}, expired bool)
+}
+
+func newBoundedCache(name string, size int, ttl time.Duration) *boundedCache {
+	c := &boundedCache{
+		name:    name,
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+	caches = append(caches, c)
+	return c
+}
+
+// get returns the value of the key unless it is missing or expired at the time.
+func (c *boundedCache) get(key string, now time.Time) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if c.expired(entry, now) {
+		c.evict(e, true)
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return entry.value, true
+}
+
+// put sets the value of the key, evicting expired entries first and then the
+// least recently used ones to make room.
+func (c *boundedCache) put(key string, value interface{}, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		entry.value, entry.at = value, now
+		c.order.MoveToFront(e)
+		return
+	}
+
+	for len(c.entries) >= c.size && c.order.Len() > 0 {
+		oldest := c.order.Back()
+		c.evict(oldest, c.expired(oldest.Value.(*cacheEntry), now))
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value, at: now})
+}
+
+// remove takes the entry of the key out of the cache, e.g., when it is done
+// with, which is neither an eviction nor an expiration.
+func (c *boundedCache) remove(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.Remove(e)
+	delete(c.entries, key)
+	return e.Value.(*cacheEntry).value, true
+}
+
+// expire removes the entries expired at the time, least recently used first.
+// Otherwise they are removed only when found by get or pushed out by put.
+func (c *boundedCache) expire(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for e := c.order.Back(); e != nil; {
+		prev := e.Prev()
+		if c.expired(e.Value.(*cacheEntry), now) {
+			c.evict(e, true)
+		}
+		e = prev
+	}
+}
+
+// walk calls the function for each entry with the time it was last put, least
+// recently used first, with the cache locked.
+func (c *boundedCache) walk(fn func(key string, value interface{}, at time.Time)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for e := c.order.Back(); e != nil; e = e.Prev() {
+		entry := e.Value.(*cacheEntry)
+		fn(entry.key, entry.value, entry.at)
+	}
+}
+
+func (c *boundedCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// close stops reporting the cache, which is no longer used, while its counts
+// still add up to those of the table.
+func (c *boundedCache) close() {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+
+	for i, cache := range caches {
+		if cache == c {
+			caches = append(caches[:i], caches[i+1:]...)
+			break
+		}
+	}
+	c.mu.Lock()
+	closed := closedCaches[c.name]
+	closed.evictions += c.evictions
+	closed.expirations += c.expirations
+	closedCaches[c.name] = closed
+	c.mu.Unlock()
+}
+
+func (c *boundedCache) expired(entry *cacheEntry, now time.Time) bool {
+	return c.ttl > 0 && now.Sub(entry.at) > c.ttl
+}
+
+func (c *boundedCache) evict(e *list.Element, expired bool) {
+	entry := e.Value.(*cacheEntry)
+	c.order.Remove(e)
+	delete(c.entries, entry.key)
+	if expired {
+		c.expirations += 1
+	} else {
+		c.evictions += 1
+	}
+	if c.onEvict != nil {
+		c.onEvict(entry.key, entry.value, expired)
+	}
+}
+
+type cacheStats struct {
+	name        string
+	entries     int
+	evictions   uint64
+	expirations uint64
+}
+
+// allCacheStats returns the statistics of the caches sorted by name, summed up
+// for those of a table, e.g., one for each connection of --listen-replay.
+func allCacheStats() []cacheStats {
+	cachesMu.Lock()
+	defer cachesMu.Unlock()
+
+	byName := map[string]cacheStats{}
+	for name, closed := range closedCaches {
+		byName[name] = closed
+	}
+	for _, c := range caches {
+		c.mu.Lock()
+		s := byName[c.name]
+		s.entries += len(c.entries)
+		s.evictions += c.evictions
+		s.expirations += c.expirations
+		byName[c.name] = s
+		c.mu.Unlock()
+	}
+	stats := make([]cacheStats, 0, len(byName))
+	for name, s := range byName {
+		s.name = name
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].name < stats[j].name })
+	return stats
+}
+
+// maxTTLCache remembers the highest TTL seen for each name and type pair.
+// Resolver caches hand out the remaining lifetime of a record, so an answer
+// with a TTL lower than the maximum observed is likely served from a cache.
+type maxTTLCache struct {
+	mu      sync.Mutex
+	entries *boundedCache
+}
+
+func newMaxTTLCache(size int) *maxTTLCache {
+	return &maxTTLCache{entries: newBoundedCache("max_ttl", size, 0)}
+}
+
+// observe records the TTL of an answer and reports whether it is lower than
+// the highest one seen before for the same name and type.
+func (c *maxTTLCache) observe(name string, qtype string, ttl uint32) bool {
+	key := name + "/" + qtype
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	max, ok := c.entries.get(key, now)
+	if ok && ttl < max.(uint32) {
+		return true
+	}
+	c.entries.put(key, ttl, now)
+	return false
+}
+
+type seenAnswer struct {
+	ip string
+	at time.Time // Last seen
+}
+
+// answerHistory remembers the answers seen recently for each name and type
+// pair, to tell a response answering an address unlike those before it, e.g.,
+// a poisoned cache or an inconsistent load balancer. An answer is forgotten
+// once it is not seen for the window.
+type answerHistory struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries *boundedCache
+}
+
+func newAnswerHistory(size int, window time.Duration) *answerHistory {
+	return &answerHistory{
+		window:  window,
+		entries: newBoundedCache("answer_churn", size, window),
+	}
+}
+
+// observe records the answer and reports whether it differs from all those
+// seen within the window for the same name and type. The first answer ever
+// seen is not new, as there is nothing to compare with.
+func (h *answerHistory) observe(name string, qtype string, ip net.IP, at time.Time) bool {
+	key := name + "/" + qtype
+	addr := ip.String()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var seen []seenAnswer
+	if value, ok := h.entries.get(key, at); ok {
+		seen = value.([]seenAnswer)
+	}
+
+	recent := seen[:0]
+	found := false
+	for _, s := range seen {
+		if at.Sub(s.at) > h.window {
+			continue
+		}
+		if s.ip == addr {
+			s.at = at
+			found = true
+		}
+		recent = append(recent, s)
+	}
+	if !found {
+		if len(recent) >= maxAnswersPerName {
+			recent = recent[1:]
+		}
+		recent = append(recent, seenAnswer{ip: addr, at: at})
+	}
+	h.entries.put(key, recent, at)
+	return !found && len(recent) > 1
+}
+
+// firstSeenFilter passes the first query of a client for a name, and
+// suppresses those repeating it until the interval since then passes.
+type firstSeenFilter struct {
+	mu      sync.Mutex
+	entries *boundedCache
+}
+
+func newFirstSeenFilter(size int, interval time.Duration) *firstSeenFilter {
+	return &firstSeenFilter{entries: newBoundedCache("first_seen", size, interval)}
+}
+
+// first reports whether the query is the first of the client for the name in
+// the interval. Names are compared regardless of case, e.g., of 0x20 encoding.
+func (f *firstSeenFilter) first(client net.IP, name string, at time.Time) bool {
+	key := client.String() + "/" + strings.ToLower(name)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.entries.get(key, at); ok {
+		return false
+	}
+	f.entries.put(key, struct{}{}, at)
+	return true
+}
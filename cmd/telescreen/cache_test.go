@@ -0,0 +1,273 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestDetectCached(t *testing.T) {
+	defer func(c *maxTTLCache) { ttlCache = c }(ttlCache)
+	ttlCache = newMaxTTLCache(maxTTLCacheEntries)
+
+	// The resolver hands out what remains of the TTL once cached
+	tests := []struct {
+		ttl    uint32
+		cached bool
+	}{
+		{300, false},
+		{240, true},
+		{300, false},
+	}
+	for _, tt := range tests {
+		packet := newResponsePacket(t, response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", tt.ttl)), 0)
+		r := newResponseLog(packet, newQueryLog(packet, newTelescreenLogCommon(packet)))
+		if r == nil {
+			t.Fatal("response not parsed")
+		}
+		if r.LikelyCached != tt.cached {
+			t.Errorf("TTL %d flagged cached %v, want %v", tt.ttl, r.LikelyCached, tt.cached)
+		}
+	}
+
+	// Another type of the name has a maximum of its own
+	if ttlCache.observe("www.example.com", "A", 60) {
+		t.Error("TTL of another type taken for the maximum")
+	}
+}
+
+func TestAnswerChurn(t *testing.T) {
+	defer func(h *answerHistory) { answerChurn = h }(answerChurn)
+	answerChurn = newAnswerHistory(maxTTLCacheEntries, time.Hour)
+
+	// Stable, then changed, then back to an address seen before
+	tests := []struct {
+		ip    string
+		after time.Duration
+		new   bool
+	}{
+		{"2001:db8::80", 0, false},
+		{"2001:db8::80", time.Minute, false},
+		{"2001:db8::bad", 2 * time.Minute, true},
+		{"2001:db8::80", 3 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		packet := newResponsePacket(t, response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", tt.ip, 300)), tt.after)
+		r := newResponseLog(packet, newQueryLog(packet, newTelescreenLogCommon(packet)))
+		if r == nil {
+			t.Fatal("response not parsed")
+		}
+		if r.NewAnswer != tt.new || strings.Contains(r.String(), "new answer") != tt.new {
+			t.Errorf("%s after %v flagged new %v, want %v: %s", tt.ip, tt.after, r.NewAnswer, tt.new, r.String())
+		}
+	}
+}
+
+func TestAnswerChurnWindow(t *testing.T) {
+	h := newAnswerHistory(maxTTLCacheEntries, time.Hour)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h.observe("www.example.com", "AAAA", net.ParseIP("2001:db8::80"), start)
+
+	// Nothing is left to compare with once the window passes
+	if h.observe("www.example.com", "AAAA", net.ParseIP("2001:db8::81"), start.Add(2*time.Hour)) {
+		t.Error("flagged new against an answer forgotten")
+	}
+	if !h.observe("www.example.com", "AAAA", net.ParseIP("2001:db8::82"), start.Add(2*time.Hour+time.Minute)) {
+		t.Error("not flagged new within the window")
+	}
+	// Another type has answers of its own
+	if h.observe("www.example.com", "A", net.ParseIP("192.0.2.1"), start.Add(2*time.Hour)) {
+		t.Error("flagged new against another type")
+	}
+}
+
+func TestBoundedCacheEvictsBySize(t *testing.T) {
+	c := newBoundedCache("test_size", 2, 0)
+	defer c.close()
+	var evicted []string
+	c.onEvict = func(key string, value interface{}, expired bool) {
+		if expired {
+			t.Errorf("%s expired without a TTL", key)
+		}
+		evicted = append(evicted, key)
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.put("a", 1, now)
+	c.put("b", 2, now)
+	// Getting a makes b the least recently used
+	if _, ok := c.get("a", now); !ok {
+		t.Fatal("a missing before the cache is full")
+	}
+	c.put("c", 3, now)
+
+	if _, ok := c.get("b", now); ok {
+		t.Error("b not evicted as the least recently used")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.get(key, now); !ok {
+			t.Errorf("%s evicted", key)
+		}
+	}
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Errorf("evicted %v, want [b]", evicted)
+	}
+	if c.len() != 2 || c.evictions != 1 || c.expirations != 0 {
+		t.Errorf("%d entries, %d evictions and %d expirations, want 2, 1 and 0", c.len(), c.evictions, c.expirations)
+	}
+}
+
+func TestBoundedCacheExpiresByTTL(t *testing.T) {
+	c := newBoundedCache("test_ttl", 10, time.Minute)
+	defer c.close()
+	expired := 0
+	c.onEvict = func(key string, value interface{}, e bool) {
+		if e {
+			expired += 1
+		}
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.put("a", 1, now)
+	c.put("b", 2, now)
+	c.put("c", 3, now.Add(30*time.Second))
+
+	// Found expired by get
+	if _, ok := c.get("a", now.Add(61*time.Second)); ok {
+		t.Error("a not expired after the TTL")
+	}
+	// Put again, which renews the entry
+	c.put("c", 3, now.Add(61*time.Second))
+	// Swept, leaving what was put within the TTL
+	c.expire(now.Add(61 * time.Second))
+	if _, ok := c.get("b", now.Add(61*time.Second)); ok {
+		t.Error("b not swept after the TTL")
+	}
+	if _, ok := c.get("c", now.Add(2*time.Minute)); !ok {
+		t.Error("c expired although put again within the TTL")
+	}
+
+	if expired != 2 || c.expirations != 2 || c.evictions != 0 {
+		t.Errorf("%d expired, %d expirations and %d evictions, want 2, 2 and 0", expired, c.expirations, c.evictions)
+	}
+}
+
+func TestBoundedCacheRemoveIsNotEviction(t *testing.T) {
+	c := newBoundedCache("test_remove", 10, time.Minute)
+	defer c.close()
+	c.onEvict = func(key string, value interface{}, expired bool) {
+		t.Errorf("%s evicted by remove", key)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.put("a", 1, now)
+	if value, ok := c.remove("a"); !ok || value.(int) != 1 {
+		t.Errorf("remove() = %v, %v, want 1, true", value, ok)
+	}
+	if _, ok := c.remove("a"); ok {
+		t.Error("removed twice")
+	}
+}
+
+func TestCacheStatsOfTable(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	stats := func() cacheStats {
+		for _, s := range allCacheStats() {
+			if s.name == "test_table" {
+				return s
+			}
+		}
+		return cacheStats{}
+	}
+
+	// The counts of a cache closed, e.g., of a replay ended, are kept
+	before := stats()
+	closed := newBoundedCache("test_table", 1, 0)
+	closed.put("a", 1, now)
+	closed.put("b", 2, now)
+	closed.close()
+	open := newBoundedCache("test_table", 1, 0)
+	defer open.close()
+	open.put("a", 1, now)
+	open.put("b", 2, now)
+
+	if s := stats(); s.entries != 1 || s.evictions-before.evictions != 2 {
+		t.Errorf("%d entries and %d evictions, want 1 and 2", s.entries, s.evictions-before.evictions)
+	}
+}
+
+func TestLatencyMetricsBounded(t *testing.T) {
+	m := newLatencyMetrics(2)
+	defer m.pending.close()
+	sent := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	query := func(id uint16, at time.Time, response bool) *QueryLog {
+		q := &QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.com", QType: "AAAA", TransID: id, isResponse: response}
+		q.Timestamp = at
+		return q
+	}
+
+	// The oldest of three queries is pushed out of the table for two
+	for id := uint16(1); id <= 3; id++ {
+		m.observe(query(id, sent, false))
+	}
+	m.observe(query(1, sent.Add(time.Millisecond), true))
+	m.observe(query(3, sent.Add(time.Millisecond), true))
+	if h := m.histograms["AAAA"]; h == nil || h.total != 1 {
+		t.Errorf("histogram %+v, want a latency of the query kept", h)
+	}
+	if m.pending.evictions != 1 {
+		t.Errorf("%d evictions, want 1", m.pending.evictions)
+	}
+
+	// The one left is never answered
+	m.observe(query(4, sent.Add(queryTimeout+2*time.Second), false))
+	if m.unanswered != 1 {
+		t.Errorf("%d unanswered, want 1", m.unanswered)
+	}
+}
+
+func TestFirstSeenFilter(t *testing.T) {
+	f := newFirstSeenFilter(maxTTLCacheEntries, time.Hour)
+	defer f.entries.close()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	client, other := net.ParseIP(testClient), net.ParseIP("2001:db8::2")
+
+	// Windows start at the query passed, not at repeats suppressed
+	tests := []struct {
+		client net.IP
+		name   string
+		after  time.Duration
+		want   bool
+	}{
+		{client, "www.example.com", 0, true},
+		{client, "www.example.com", time.Minute, false},
+		{client, "WWW.example.com", 30 * time.Minute, false},
+		{other, "www.example.com", 30 * time.Minute, true},
+		{client, "mail.example.com", 30 * time.Minute, true},
+		{client, "www.example.com", 59 * time.Minute, false},
+		{client, "www.example.com", 61 * time.Minute, true},
+		{client, "www.example.com", 90 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		if got := f.first(tt.client, tt.name, start.Add(tt.after)); got != tt.want {
+			t.Errorf("%s from %s after %v passed %v, want %v", tt.name, tt.client, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestFirstSeenInterval(t *testing.T) {
+	defer func(f *firstSeenFilter) { firstSeen = f }(firstSeen)
+	firstSeen = newFirstSeenFilter(maxTTLCacheEntries, time.Hour)
+	defer firstSeen.entries.close()
+
+	logs := interceptAll(
+		newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA)),
+		newQueryPacket(t, query("www.example.com", layers.DNSTypeA)),
+		newQueryPacket(t, query("mail.example.com", layers.DNSTypeAAAA)),
+	)
+	if got, want := queryNames(logs), "www.example.com,mail.example.com"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CombinedLog is a query and its response in one record with --combined. The
+// common fields are those of the query, i.e., it is timestamped when the query
+// is sent, and the answer fields are left empty if it timed out.
+type CombinedLog struct {
+	tableName struct{} `pg:"combined_logs"`
+	ResponseLog
+	LatencyUs int64 `pg:"latency_us,use_zero" json:"latency_us"` // From the query to the response, 0 if timed out
+	Timeout   bool  `pg:"timeout,notnull,use_zero" json:"timeout"`
+}
+
+func (c *CombinedLog) String() string {
+	ts := formatTime(c.Timestamp)
+	src := fmt.Sprintf("%s.%d", c.SrcIP.String(), c.SrcPort)
+	dst := fmt.Sprintf("%s.%d", c.DstIP.String(), c.DstPort)
+	qtype := fmt.Sprintf("%s?", c.QType)
+	answer := "timeout"
+	if !c.Timeout {
+		answer = fmt.Sprintf("%s, in %v", c.answerDetail(), time.Duration(c.LatencyUs)*time.Microsecond)
+	}
+	return fmt.Sprintf("%s | %s%-43s = %-25s %s %-5d %-8s %s [%s] (%s)%s", ts, c.capturePoint(), src, dst, c.transportName(), c.TransID, qtype, c.displayName(), c.AnsTypes, answer, c.fieldsString())
+}
+
+func (c *CombinedLog) Colorize() string {
+	switch {
+	case c.Timeout:
+		return c.String()
+	case c.IPv6Ready:
+		return fmt.Sprintf("\033[0;34m%s\033[0m", c.String())
+	default:
+		return fmt.Sprintf("\033[0;35m%s\033[0m", c.String())
+	}
+}
+
+// newTimedOutLog returns the query given up waiting for its response.
+func newTimedOutLog(q *QueryLog) *CombinedLog {
+	combined := &CombinedLog{Timeout: true}
+	combined.QueryLog = *q
+	return combined
+}
+
+// correlationKey identifies the query a response answers.
+func correlationKey(q *QueryLog) string {
+	return fmt.Sprintf("%s.%d|%s.%d|%d|%s|%s", q.ClientIP, q.ClientPort, q.ServerIP, q.ServerPort, q.TransID, q.QString, q.QType)
+}
+
+// queryCombiner holds queries until their responses arrive, to export both as
+// a CombinedLog. Queries not answered within the timeout, judged by the time
+// of the packets captured, or pushed out by newer ones when full are exported
+// as timed out. Responses whose query was not captured are exported as they
+// are, and logs other than queries and responses pass through.
+type queryCombiner struct {
+	mu       sync.Mutex
+	pending  *boundedCache   // Queries by correlationKey
+	timedOut []telescreenLog // Given up by pending since the last pass
+}
+
+func newQueryCombiner(size int, timeout time.Duration) *queryCombiner {
+	c := &queryCombiner{pending: newBoundedCache("combined_pending", size, timeout)}
+	c.pending.onEvict = func(key string, value interface{}, expired bool) {
+		c.timedOut = append(c.timedOut, newTimedOutLog(value.(*QueryLog)))
+	}
+	return c
+}
+
+// pass takes a log and returns the logs to be exported now.
+func (c *queryCombiner) pass(log telescreenLog) []telescreenLog {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch l := log.(type) {
+	case *QueryLog:
+		c.pending.expire(l.Timestamp)
+		key := correlationKey(l)
+		// A retransmission is timed from the first query
+		if _, ok := c.pending.get(key, l.Timestamp); !ok {
+			c.pending.put(key, l, l.Timestamp)
+		}
+		return c.takeTimedOut()
+	case *ResponseLog:
+		c.pending.expire(l.Timestamp)
+		logs := c.takeTimedOut()
+		value, ok := c.pending.remove(correlationKey(&l.QueryLog))
+		if !ok {
+			return append(logs, l)
+		}
+		q := value.(*QueryLog)
+
+		combined := &CombinedLog{ResponseLog: *l}
+		combined.QueryLog = *q
+		// Enrichers saw the query and the response apart, so the fields of
+		// both are kept, those of the query first as the record is of it
+		if len(l.Fields) > 0 {
+			fields := make(map[string]string, len(l.Fields)+len(q.Fields))
+			for k, v := range l.Fields {
+				fields[k] = v
+			}
+			for k, v := range q.Fields {
+				fields[k] = v
+			}
+			combined.Fields = fields
+		}
+		combined.LatencyUs = l.Timestamp.Sub(q.Timestamp).Microseconds()
+		return append(logs, combined)
+	default:
+		return []telescreenLog{log}
+	}
+}
+
+// flush gives up all the queries pending, oldest first, e.g., when the capture
+// ends.
+func (c *queryCombiner) flush() []telescreenLog {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var keys []string
+	c.pending.walk(func(key string, value interface{}, at time.Time) {
+		keys = append(keys, key)
+	})
+	logs := c.takeTimedOut()
+	for _, key := range keys {
+		if value, ok := c.pending.remove(key); ok {
+			logs = append(logs, newTimedOutLog(value.(*QueryLog)))
+		}
+	}
+	return logs
+}
+
+func (c *queryCombiner) takeTimedOut() []telescreenLog {
+	logs := c.timedOut
+	c.timedOut = nil
+	return logs
+}
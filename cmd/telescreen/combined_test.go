@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// withCombiner runs the test with --combined.
+func withCombiner(t *testing.T, timeout time.Duration) {
+	capture, nets := captureFlag, answerNets
+	captureFlag, combiner = true, newQueryCombiner(maxTTLCacheEntries, timeout)
+	t.Cleanup(func() {
+		combiner.pending.close()
+		captureFlag, answerNets, combiner = capture, nets, nil
+	})
+}
+
+func TestCombinedMatched(t *testing.T) {
+	withCombiner(t, time.Second)
+
+	logs := interceptAll(
+		newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA)),
+		newResponsePacket(t, response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300)), 30*time.Millisecond),
+	)
+	if len(logs) != 1 {
+		t.Fatalf("exported %d logs, want 1", len(logs))
+	}
+	c, ok := logs[0].(*CombinedLog)
+	if !ok {
+		t.Fatalf("exported %T, want *CombinedLog", logs[0])
+	}
+	if c.Timeout || c.LatencyUs != 30000 {
+		t.Errorf("timeout %v in %dus, want answered in 30000us", c.Timeout, c.LatencyUs)
+	}
+	// Timestamped and addressed as the query
+	if c.SrcIP.String() != testClient || c.SrcPort != 40000 || c.AnsIP.String() != "2001:db8::80" {
+		t.Errorf("%s.%d answered %s, want %s.40000 answered 2001:db8::80", c.SrcIP, c.SrcPort, c.AnsIP, testClient)
+	}
+	if combiner.pending.len() != 0 {
+		t.Errorf("%d queries still pending", combiner.pending.len())
+	}
+}
+
+func TestCombinedTimedOut(t *testing.T) {
+	withCombiner(t, time.Second)
+
+	// The first query is given up by a packet after the timeout, and the
+	// second when the capture ends
+	late := newQueryPacket(t, query("late.example.com", layers.DNSTypeA))
+	late.Metadata().Timestamp = late.Metadata().Timestamp.Add(2 * time.Second)
+	logs := interceptAll(newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA)), late)
+	logs = append(logs, combiner.flush()...)
+
+	if len(logs) != 2 {
+		t.Fatalf("exported %d logs, want 2", len(logs))
+	}
+	for i, name := range []string{"www.example.com", "late.example.com"} {
+		c, ok := logs[i].(*CombinedLog)
+		if !ok {
+			t.Fatalf("exported %T, want *CombinedLog", logs[i])
+		}
+		if !c.Timeout || c.QString != name || c.AnsIP != nil || c.LatencyUs != 0 {
+			t.Errorf("logs[%d] for %s, timeout %v, answered %v in %dus, want %s timed out", i, c.QString, c.Timeout, c.AnsIP, c.LatencyUs, name)
+		}
+	}
+}
+
+func TestCombinedFilteredResponse(t *testing.T) {
+	withCombiner(t, time.Second)
+	_, n, _ := net.ParseCIDR("2001:db8:bad::/48")
+	answerNets = []*net.IPNet{n}
+
+	// A query answered outside --answer-cidr is dropped with its response, not
+	// timed out
+	logs := interceptAll(
+		newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA)),
+		newResponsePacket(t, response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300)), time.Millisecond),
+	)
+	logs = append(logs, combiner.flush()...)
+	if len(logs) != 0 {
+		t.Fatalf("exported %d logs, want none", len(logs))
+	}
+
+	logs = interceptAll(
+		newQueryPacket(t, query("bad.example.com", layers.DNSTypeAAAA)),
+		newResponsePacket(t, response("bad.example.com", layers.DNSTypeAAAA, aaaa("bad.example.com", "2001:db8:bad::1", 300)), time.Millisecond),
+	)
+	if len(logs) != 1 {
+		t.Fatalf("exported %d logs, want 1", len(logs))
+	}
+	if c, ok := logs[0].(*CombinedLog); !ok || c.Timeout {
+		t.Errorf("exported %v, want the answer in --answer-cidr", logs[0])
+	}
+}
+
+func TestCombinedFields(t *testing.T) {
+	withCombiner(t, time.Second)
+	defer func(site string) { testSite = site }(testSite)
+	testSite = "tokyo"
+
+	logs := interceptAll(
+		newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA)),
+		newResponsePacket(t, response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300)), time.Millisecond),
+	)
+	if len(logs) != 1 {
+		t.Fatalf("exported %d logs, want 1", len(logs))
+	}
+	if got := logs[0].String(); !strings.HasSuffix(got, " {site=tokyo}") {
+		t.Errorf("got %q, want the site", got)
+	}
+	if record := csvRecord(logs[0]); record[len(record)-1] != `{"site":"tokyo"}` {
+		t.Errorf("got CSV fields %q, want the site", record[len(record)-1])
+	}
+	if event := newDnsEvent(logs[0]); !event.Combined || event.Fields["site"] != "tokyo" {
+		t.Errorf("got combined %v with fields %v in the event, want the site", event.Combined, event.Fields)
+	}
+}
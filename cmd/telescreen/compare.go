@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	compareWorkers   int           = 4               // Lookups to the reference resolver at once
+	compareQueueSize int           = 1024            // Responses waiting for a lookup, skipped beyond this
+	compareTimeout   time.Duration = 2 * time.Second // Bounds a lookup to the reference resolver
+)
+
+// resolverComparer looks up the names of the captured A and AAAA responses on
+// a reference resolver, and exports only the responses whose addresses differ
+// from those it answers, along with the other logs. Lookups are made by workers off the capture path at
+// most at a rate, and responses beyond it or a full queue are skipped.
+type resolverComparer struct {
+	addr      string
+	limiter   *rateLimiter
+	queue     chan *ResponseLog
+	exporters []func(telescreenLog)
+	wg        sync.WaitGroup
+	skipped   uint64 // Responses not compared for a full queue
+	failed    uint64 // Lookups the reference resolver did not answer
+}
+
+func newResolverComparer(addr string, rate float64, exporters []func(telescreenLog)) *resolverComparer {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "53")
+	}
+	c := &resolverComparer{
+		addr:      addr,
+		limiter:   newRateLimiter(rate),
+		queue:     make(chan *ResponseLog, compareQueueSize),
+		exporters: exporters,
+	}
+	for i := 0; i < compareWorkers; i++ {
+		c.wg.Add(1)
+		go c.work()
+	}
+	return c
+}
+
+// submit queues an A or AAAA response for a lookup without blocking, and
+// passes anything else on to the exporters as it is.
+func (c *resolverComparer) submit(qr telescreenLog) {
+	r, ok := qr.(*ResponseLog)
+	if !ok || (r.QType != "A" && r.QType != "AAAA") || r.Opcode != "QUERY" {
+		for _, exporter := range c.exporters {
+			exporter(qr)
+		}
+		return
+	}
+	if !c.limiter.allow(time.Now()) {
+		return
+	}
+	select {
+	case c.queue <- r:
+	default:
+		atomic.AddUint64(&c.skipped, 1)
+	}
+}
+
+func (c *resolverComparer) work() {
+	defer c.wg.Done()
+	for r := range c.queue {
+		qtype := layers.DNSTypeA
+		if r.QType == "AAAA" {
+			qtype = layers.DNSTypeAAAA
+		}
+		ref, err := lookupReference(c.addr, r.QString, qtype, compareTimeout)
+		if err != nil {
+			atomic.AddUint64(&c.failed, 1)
+			continue
+		}
+		if sameAddresses(r.answerIPs, ref) {
+			continue
+		}
+
+		r.Mismatch = true
+		addrs := make([]string, len(ref))
+		for i, ip := range ref {
+			addrs[i] = ip.String()
+		}
+		r.RefAnswer = strings.Join(addrs, ",")
+		for _, exporter := range c.exporters {
+			exporter(r)
+		}
+	}
+}
+
+// close waits for the queued lookups and reports the responses not compared.
+func (c *resolverComparer) close() {
+	close(c.queue)
+	c.wg.Wait()
+	if n := c.limiter.droppedLogs() + atomic.LoadUint64(&c.skipped); n > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped comparing %d responses over --compare-rate\n", n)
+	}
+	if n := atomic.LoadUint64(&c.failed); n > 0 {
+		fmt.Fprintf(os.Stderr, "Failed to look up %d names on --compare-resolver\n", n)
+	}
+}
+
+// lookupReference asks the resolver for the addresses of the name over UDP.
+func lookupReference(addr string, name string, qtype layers.DNSType, timeout time.Duration) ([]net.IP, error) {
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	query := &layers.DNS{
+		ID:        uint16(rand.Uint32()),
+		RD:        true,
+		OpCode:    layers.DNSOpCodeQuery,
+		Questions: []layers.DNSQuestion{{Name: []byte(strings.TrimSuffix(name, ".")), Type: qtype, Class: layers.DNSClassIN}},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := query.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	// Stray datagrams, e.g., late responses to another query, are ignored
+	b := make([]byte, 65535)
+	for {
+		n, err := conn.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		var response layers.DNS
+		if err := response.DecodeFromBytes(b[:n], gopacket.NilDecodeFeedback); err != nil {
+			continue
+		}
+		if !response.QR || response.ID != query.ID {
+			continue
+		}
+		var ips []net.IP
+		for _, record := range response.Answers {
+			if record.Type == qtype && record.IP != nil {
+				ips = append(ips, record.IP)
+			}
+		}
+		return ips, nil
+	}
+}
+
+// sameAddresses reports whether the two hold the same set of addresses,
+// regardless of the order.
+func sameAddresses(a, b []net.IP) bool {
+	set := func(ips []net.IP) []string {
+		addrs := map[string]bool{}
+		for _, ip := range ips {
+			addrs[ip.String()] = true
+		}
+		keys := make([]string, 0, len(addrs))
+		for addr := range addrs {
+			keys = append(keys, addr)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	return strings.Join(set(a), ",") == strings.Join(set(b), ",")
+}
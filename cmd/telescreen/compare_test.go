@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// serveReference answers AAAA queries over UDP with the address of the name in
+// answers, and with no answer for other names.
+func serveReference(t *testing.T, answers map[string]string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		b := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			var query layers.DNS
+			if err := query.DecodeFromBytes(b[:n], gopacket.NilDecodeFeedback); err != nil || len(query.Questions) != 1 {
+				continue
+			}
+			name := string(query.Questions[0].Name)
+			dns := response(name, query.Questions[0].Type)
+			dns.ID = query.ID
+			if ip, ok := answers[name]; ok {
+				dns.Answers = append(dns.Answers, aaaa(name, ip, 300))
+			}
+			buf := gopacket.NewSerializeBuffer()
+			if err := dns.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+				t.Error(err)
+				return
+			}
+			conn.WriteTo(buf.Bytes(), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestCompareResolver(t *testing.T) {
+	defer func(capture bool) { captureFlag = capture }(captureFlag)
+	captureFlag = true
+
+	addr := serveReference(t, map[string]string{
+		"same.example.com":   "2001:db8::80",
+		"differ.example.com": "2001:db8::bad",
+	})
+	var mu sync.Mutex
+	var exported []telescreenLog
+	c := newResolverComparer(addr, 100, []func(telescreenLog){func(l telescreenLog) {
+		mu.Lock()
+		defer mu.Unlock()
+		exported = append(exported, l)
+	}})
+
+	logs := interceptAll(
+		newQueryPacket(t, query("same.example.com", layers.DNSTypeAAAA)),
+		newResponsePacket(t, response("same.example.com", layers.DNSTypeAAAA, aaaa("same.example.com", "2001:db8::80", 300)), 0),
+		newResponsePacket(t, response("differ.example.com", layers.DNSTypeAAAA, aaaa("differ.example.com", "2001:db8::80", 300)), 0),
+	)
+	for _, l := range logs {
+		c.submit(l)
+	}
+	c.close()
+
+	// The query is passed on, and only the response the reference disagrees with
+	if len(exported) != 2 {
+		t.Fatalf("exported %d logs, want the query and a response", len(exported))
+	}
+	if q, ok := exported[0].(*QueryLog); !ok || q.QString != "same.example.com" {
+		t.Errorf("exported %v first, want the query", exported[0])
+	}
+	r, ok := exported[1].(*ResponseLog)
+	if !ok {
+		t.Fatalf("exported %T, want *ResponseLog", exported[1])
+	}
+	if r.QString != "differ.example.com" || !r.Mismatch || r.RefAnswer != "2001:db8::bad" {
+		t.Errorf("exported %s with mismatch %v and reference %q, want differ.example.com with 2001:db8::bad", r.QString, r.Mismatch, r.RefAnswer)
+	}
+}
+
+func TestSameAddresses(t *testing.T) {
+	ips := func(addrs ...string) []net.IP {
+		var ips []net.IP
+		for _, addr := range addrs {
+			ips = append(ips, net.ParseIP(addr))
+		}
+		return ips
+	}
+	tests := []struct {
+		a, b []net.IP
+		want bool
+	}{
+		{ips("2001:db8::1", "2001:db8::2"), ips("2001:db8::2", "2001:db8::1"), true},
+		{ips("2001:db8::1", "2001:db8::1"), ips("2001:db8::1"), true},
+		{ips("2001:db8::1"), ips("2001:db8::2"), false},
+		{ips("2001:db8::1"), nil, false},
+		{nil, nil, true},
+	}
+	for _, tt := range tests {
+		if got := sameAddresses(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameAddresses(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/go-sql-driver/mysql"
+)
+
+// Logs stored in the database, one table each
+var schemas = []interface{}{
+	(*QueryLog)(nil),
+	(*ResponseLog)(nil),
+	(*DoTLog)(nil),
+	(*CombinedLog)(nil),
+}
+
+// dbBackend stores logs in a database of some kind. Tables and columns are
+// named after the pg tags of the logs whichever the backend is.
+type dbBackend interface {
+	createTable(schema interface{}) error
+	insert(qr telescreenLog) error
+	close() error
+}
+
+type pgBackend struct {
+	db *pg.DB
+}
+
+func newPGBackend(addr, name, user, password string) *pgBackend {
+	return &pgBackend{db: pg.Connect(&pg.Options{
+		Addr:     addr,
+		User:     user,
+		Password: password,
+		Database: name,
+	})}
+}
+
+func (b *pgBackend) createTable(schema interface{}) error {
+	err := b.db.Model(schema).CreateTable(&orm.CreateTableOptions{
+		IfNotExists: true,
+	})
+	if err != nil {
+		return err
+	}
+	return b.addMissingColumns(schema)
+}
+
+// addMissingColumns brings a table created by an older release up to date,
+// since CREATE TABLE IF NOT EXISTS leaves an existing table untouched.
+func (b *pgBackend) addMissingColumns(schema interface{}) error {
+	table := orm.GetTable(reflect.TypeOf(schema).Elem())
+	for _, field := range table.Fields {
+		_, err := b.db.Exec("ALTER TABLE ? ADD COLUMN IF NOT EXISTS ? ?", table.SQLName, field.Column, pg.Safe(field.SQLType))
+		if err != nil {
+			return fmt.Errorf("failed to add column %s to %s: %w", field.SQLName, table.SQLName, err)
+		}
+	}
+	return nil
+}
+
+func (b *pgBackend) insert(qr telescreenLog) error {
+	_, err := b.db.Model(qr).Insert()
+	return err
+}
+
+func (b *pgBackend) close() error {
+	return b.db.Close()
+}
+
+// mysqlBackend stores logs in MySQL or MariaDB through database/sql. The
+// tables mirror those go-pg creates on Postgres, with the closest types.
+type mysqlBackend struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	inserts map[reflect.Type]string // INSERT statement per log type
+}
+
+func newMySQLBackend(addr, name, user, password string) (*mysqlBackend, error) {
+	config := mysql.NewConfig()
+	config.Net = "tcp"
+	config.Addr = addr
+	config.DBName = name
+	config.User = user
+	config.Passwd = password
+	config.ParseTime = true
+	config.Loc = time.UTC
+
+	db, err := sql.Open("mysql", config.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+	return &mysqlBackend{db: db, inserts: map[reflect.Type]string{}}, nil
+}
+
+// mysqlTableName strips the quotes go-pg puts around table names.
+func mysqlTableName(table *orm.Table) string {
+	return strings.Trim(string(table.SQLName), `"`)
+}
+
+// mysqlType maps the Go type of a log field to a column type.
+func mysqlType(field *orm.Field) string {
+	switch field.Type {
+	case reflect.TypeOf(time.Time{}):
+		return "DATETIME(6)"
+	case reflect.TypeOf(net.IP{}):
+		return "VARCHAR(45)"
+	}
+	switch field.Type.Kind() {
+	case reflect.Bool:
+		return "BOOLEAN NOT NULL DEFAULT FALSE"
+	case reflect.Uint16:
+		return "SMALLINT UNSIGNED"
+	case reflect.Map, reflect.Slice:
+		return "JSON"
+	case reflect.Int64:
+		return "BIGINT"
+	default:
+		return "TEXT"
+	}
+}
+
+func (b *mysqlBackend) createTable(schema interface{}) error {
+	table := orm.GetTable(reflect.TypeOf(schema).Elem())
+	name := mysqlTableName(table)
+
+	columns := make([]string, len(table.Fields))
+	for i, field := range table.Fields {
+		columns[i] = fmt.Sprintf("`%s` %s", field.SQLName, mysqlType(field))
+	}
+	_, err := b.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` (%s)", name, strings.Join(columns, ", ")))
+	if err != nil {
+		return err
+	}
+
+	// MySQL has no ADD COLUMN IF NOT EXISTS unlike MariaDB and Postgres
+	rows, err := b.db.Query("SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?", name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	existing := map[string]bool{}
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return err
+		}
+		existing[strings.ToLower(column)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, field := range table.Fields {
+		if existing[field.SQLName] {
+			continue
+		}
+		_, err := b.db.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` %s", name, field.SQLName, mysqlType(field)))
+		if err != nil {
+			return fmt.Errorf("failed to add column %s to %s: %w", field.SQLName, name, err)
+		}
+	}
+	return nil
+}
+
+func (b *mysqlBackend) insert(qr telescreenLog) error {
+	v := reflect.ValueOf(qr).Elem()
+	table := orm.GetTable(v.Type())
+
+	args := make([]interface{}, len(table.Fields))
+	for i, field := range table.Fields {
+		switch value := field.Value(v).Interface().(type) {
+		case net.IP:
+			if value != nil {
+				args[i] = value.String()
+			}
+		case map[string]string, []extendedError:
+			// Stored as JSON like go-pg does on Postgres
+			if !reflect.ValueOf(value).IsNil() {
+				b, err := json.Marshal(value)
+				if err != nil {
+					return err
+				}
+				args[i] = string(b)
+			}
+		default:
+			args[i] = value
+		}
+	}
+
+	_, err := b.db.Exec(b.insertStatement(table), args...)
+	return err
+}
+
+// insertStatement builds the INSERT statement for the table once.
+func (b *mysqlBackend) insertStatement(table *orm.Table) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if statement, ok := b.inserts[table.Type]; ok {
+		return statement
+	}
+	columns := make([]string, len(table.Fields))
+	for i, field := range table.Fields {
+		columns[i] = fmt.Sprintf("`%s`", field.SQLName)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	statement := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s)", mysqlTableName(table), strings.Join(columns, ", "), placeholders)
+	b.inserts[table.Type] = statement
+	return statement
+}
+
+func (b *mysqlBackend) close() error {
+	return b.db.Close()
+}
+
+// newDBBackend connects to the database by the driver, postgres or mysql.
+func newDBBackend(driver, addr, name, user, password string) (dbBackend, error) {
+	switch driver {
+	case "postgres":
+		return newPGBackend(addr, name, user, password), nil
+	case "mysql":
+		return newMySQLBackend(addr, name, user, password)
+	default:
+		return nil, fmt.Errorf("unknown database driver: %s", driver)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/google/gopacket/layers"
+)
+
+func newTestResponseLog(t *testing.T) *ResponseLog {
+	t.Helper()
+	packet := newResponsePacket(t, response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300)), time.Millisecond)
+	r := newResponseLog(packet, newQueryLog(packet, newTelescreenLogCommon(packet)))
+	if r == nil {
+		t.Fatal("response not parsed")
+	}
+	return r
+}
+
+// fakePostgres answers go-pg over the wire protocol, acknowledging every
+// query and recording it. sqlmock cannot stand in here, as go-pg does not go
+// through database/sql.
+type fakePostgres struct {
+	mu      sync.Mutex
+	queries []string
+	fail    string // Queries containing this fail
+}
+
+func (s *fakePostgres) connect() *pg.DB {
+	return pg.Connect(&pg.Options{
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go s.serve(server)
+			return client, nil
+		},
+	})
+}
+
+func (s *fakePostgres) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// The startup message alone comes without a type
+	var n int32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(n-4)); err != nil {
+		return
+	}
+	writePGMessage(conn, 'R', []byte{0, 0, 0, 0})
+	writePGMessage(conn, 'Z', []byte{'I'})
+
+	for {
+		typ, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return
+		}
+		body := make([]byte, n-4)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		if typ != 'Q' {
+			return
+		}
+
+		query := strings.TrimSuffix(string(body), "\x00")
+		s.mu.Lock()
+		s.queries = append(s.queries, query)
+		fail := s.fail != "" && strings.Contains(query, s.fail)
+		s.mu.Unlock()
+		switch {
+		case fail:
+			writePGMessage(conn, 'E', []byte("SERROR\x00C42601\x00Mfailed by the test\x00\x00"))
+		case strings.HasPrefix(query, "INSERT"):
+			writePGMessage(conn, 'C', []byte("INSERT 0 1\x00"))
+		default:
+			writePGMessage(conn, 'C', []byte(strings.Fields(query)[0]+"\x00"))
+		}
+		writePGMessage(conn, 'Z', []byte{'I'})
+	}
+}
+
+func writePGMessage(w io.Writer, typ byte, body []byte) {
+	b := []byte{typ, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], uint32(len(body)+4))
+	w.Write(append(b, body...))
+}
+
+func TestPGBackendMigratesAndInserts(t *testing.T) {
+	s := new(fakePostgres)
+	backend := &pgBackend{db: s.connect()}
+	defer backend.close()
+
+	if err := backend.createTable((*ResponseLog)(nil)); err != nil {
+		t.Fatalf("createTable() = %v", err)
+	}
+	if err := backend.insert(newTestResponseLog(t)); err != nil {
+		t.Fatalf("insert() = %v", err)
+	}
+
+	s.mu.Lock()
+	queries := strings.Join(s.queries, "\n")
+	s.mu.Unlock()
+	if !strings.HasPrefix(queries, `CREATE TABLE IF NOT EXISTS "response_logs"`) {
+		t.Errorf("table not created first: %s", queries)
+	}
+	// Every column is added to a table created by an older release
+	for _, field := range orm.GetTable(reflect.TypeOf(ResponseLog{})).Fields {
+		if !strings.Contains(queries, `ALTER TABLE "response_logs" ADD COLUMN IF NOT EXISTS "`+field.SQLName+`" `) {
+			t.Errorf("column %s not added", field.SQLName)
+		}
+	}
+	if !strings.Contains(queries, `INSERT INTO "response_logs"`) || !strings.Contains(queries, `'2001:db8::80'`) {
+		t.Errorf("response not inserted: %s", queries)
+	}
+}
+
+func TestPGBackendMigrationError(t *testing.T) {
+	s := &fakePostgres{fail: `ADD COLUMN IF NOT EXISTS "answer_section"`}
+	backend := &pgBackend{db: s.connect()}
+	defer backend.close()
+
+	err := backend.createTable((*ResponseLog)(nil))
+	if err == nil || !strings.Contains(err.Error(), "answer_section") {
+		t.Errorf("createTable() = %v, want failing to add answer_section", err)
+	}
+}
+
+func TestMySQLBackendMigratesAndInserts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &mysqlBackend{db: db, inserts: map[reflect.Type]string{}}
+	table := orm.GetTable(reflect.TypeOf(ResponseLog{}))
+
+	// The table was created by an older release without the last two columns
+	columns := sqlmock.NewRows([]string{"column_name"})
+	for _, field := range table.Fields {
+		if field.SQLName != "likely_cached" && field.SQLName != "answer_section" {
+			columns.AddRow(strings.ToUpper(field.SQLName))
+		}
+	}
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS `response_logs` (")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT column_name FROM information_schema.columns")).WithArgs("response_logs").WillReturnRows(columns)
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE `response_logs` ADD COLUMN `likely_cached` BOOLEAN NOT NULL DEFAULT FALSE")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE `response_logs` ADD COLUMN `answer_section` TEXT")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Addresses are stored as text
+	args := make([]driver.Value, len(table.Fields))
+	for i, field := range table.Fields {
+		switch field.SQLName {
+		case "query_string":
+			args[i] = "www.example.com"
+		case "answer_ip":
+			args[i] = "2001:db8::80"
+		default:
+			args[i] = sqlmock.AnyArg()
+		}
+	}
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `response_logs` (")).WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err := backend.createTable((*ResponseLog)(nil)); err != nil {
+		t.Fatalf("createTable() = %v", err)
+	}
+	if err := backend.insert(newTestResponseLog(t)); err != nil {
+		t.Fatalf("insert() = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	maxDatagramSize int           = 65535            // Largest payload without a jumbogram, given up beyond this
+	fragmentTimeout time.Duration = 30 * time.Second // Incomplete datagrams are given up after this
+)
+
+type ip6Fragment struct {
+	offset int
+	data   []byte
+}
+
+type ip6Datagram struct {
+	ip6       *layers.IPv6 // Header of the first fragment seen
+	next      layers.IPProtocol
+	fragments []ip6Fragment
+	size      int // Of the fragments held, which may overlap
+	length    int // Known once the last fragment arrives, 0 until then
+}
+
+// ip6Defragmenter reassembles fragmented IPv6 datagrams, e.g., large responses
+// with DNSSEC records, so that the DNS layer is decoded from the whole. It
+// holds a bounded number of datagrams of a bounded size for a bounded time, and
+// counts those given up as dropped. Each source of packets has its own, as the
+// time is told by the packets.
+type ip6Defragmenter struct {
+	mu        sync.Mutex
+	datagrams *boundedCache // Held since the first fragment seen
+	lastSweep time.Time
+}
+
+func newIP6Defragmenter(size int) *ip6Defragmenter {
+	d := &ip6Defragmenter{datagrams: newBoundedCache("fragments", size, fragmentTimeout)}
+	d.datagrams.onEvict = func(key string, value interface{}, expired bool) {
+		countDrop(dropFragmentLost)
+	}
+	return d
+}
+
+// close stops reporting the datagrams held, once the source ends.
+func (d *ip6Defragmenter) close() {
+	d.datagrams.close()
+}
+
+// process returns the packet as it is unless it is a fragment. A fragment is
+// held and nil is returned, until the last missing one comes to complete the
+// datagram, which is then returned as a new packet. Fragments held and
+// datagrams given up are counted as dropped, a fragment only once.
+func (d *ip6Defragmenter) process(packet gopacket.Packet) gopacket.Packet {
+	fragLayer := packet.Layer(layers.LayerTypeIPv6Fragment)
+	ip6Layer := packet.Layer(layers.LayerTypeIPv6)
+	if fragLayer == nil || ip6Layer == nil {
+		return packet
+	}
+	frag, _ := fragLayer.(*layers.IPv6Fragment)
+	ip6, _ := ip6Layer.(*layers.IPv6)
+
+	at := packet.Metadata().Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sweep(at)
+
+	key := fmt.Sprintf("%s|%s|%d", ip6.SrcIP, ip6.DstIP, frag.Identification)
+	var datagram *ip6Datagram
+	if value, ok := d.datagrams.get(key, at); ok {
+		datagram = value.(*ip6Datagram)
+	} else {
+		// Put only once, so that it expires after the first fragment
+		datagram = &ip6Datagram{ip6: ip6, next: frag.NextHeader}
+		d.datagrams.put(key, datagram, at)
+	}
+
+	offset := int(frag.FragmentOffset) * 8
+	data := append([]byte(nil), frag.LayerPayload()...)
+	datagram.fragments = append(datagram.fragments, ip6Fragment{offset: offset, data: data})
+	datagram.size += len(data)
+	if datagram.size > maxDatagramSize || offset+len(data) > maxDatagramSize {
+		d.datagrams.remove(key)
+		trace(packet, dropFragmentLost)
+		return nil
+	}
+	if !frag.MoreFragments {
+		datagram.length = offset + len(data)
+	}
+
+	payload := datagram.reassemble()
+	if payload == nil {
+		trace(packet, dropFragmentHeld)
+		return nil
+	}
+	d.datagrams.remove(key)
+
+	// Decode again as if the datagram were never fragmented
+	header := *datagram.ip6
+	header.NextHeader = datagram.next
+	header.HopByHop = nil
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, &header, gopacket.Payload(payload))
+	if err != nil {
+		trace(packet, dropFragmentLost)
+		return nil
+	}
+	reassembled := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv6, gopacket.Default)
+	reassembled.Metadata().CaptureInfo = packet.Metadata().CaptureInfo
+	return reassembled
+}
+
+// reassemble returns the payload if the fragments cover it from the beginning
+// to the end, otherwise nil.
+func (datagram *ip6Datagram) reassemble() []byte {
+	if datagram.length == 0 {
+		return nil
+	}
+	sort.Slice(datagram.fragments, func(i, j int) bool {
+		return datagram.fragments[i].offset < datagram.fragments[j].offset
+	})
+
+	covered := 0
+	for _, fragment := range datagram.fragments {
+		if fragment.offset > covered {
+			return nil
+		}
+		if end := fragment.offset + len(fragment.data); end > covered {
+			covered = end
+		}
+	}
+	if covered < datagram.length {
+		return nil
+	}
+
+	payload := make([]byte, datagram.length)
+	for _, fragment := range datagram.fragments {
+		if fragment.offset < len(payload) {
+			copy(payload[fragment.offset:], fragment.data)
+		}
+	}
+	return payload
+}
+
+// sweep gives up the datagrams not completed in time, once a second.
+func (d *ip6Defragmenter) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < time.Second {
+		return
+	}
+	d.lastSweep = now
+	d.datagrams.expire(now)
+}
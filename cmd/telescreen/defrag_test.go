@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// newFragment builds a fragment of a datagram from the server to the client,
+// carrying the data of the UDP datagram at the offset.
+func newFragment(t *testing.T, id uint32, offset int, data []byte, more bool, at time.Time) gopacket.Packet {
+	t.Helper()
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, DstMAC: net.HardwareAddr{0, 1, 2, 3, 4, 6}, EthernetType: layers.EthernetTypeIPv6}
+	ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolIPv6Fragment, SrcIP: net.ParseIP(testServer), DstIP: net.ParseIP(testClient)}
+
+	header := make([]byte, 8)
+	header[0] = byte(layers.IPProtocolUDP)
+	flags := uint16(offset/8) << 3
+	if more {
+		flags |= 1
+	}
+	binary.BigEndian.PutUint16(header[2:4], flags)
+	binary.BigEndian.PutUint32(header[4:8], id)
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, eth, ip6, gopacket.Payload(append(header, data...))); err != nil {
+		t.Fatal(err)
+	}
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	packet.Metadata().Timestamp = at
+	return packet
+}
+
+// largeResponse returns the UDP datagram of a response too large for a packet.
+func largeResponse(t *testing.T) []byte {
+	t.Helper()
+	var answers []layers.DNSResourceRecord
+	for i := 0; i < 80; i++ {
+		answers = append(answers, aaaa("big.example.com", fmt.Sprintf("2001:db8::%x", i+1), 60))
+	}
+	packet := newResponsePacket(t, response("big.example.com", layers.DNSTypeAAAA, answers...), 0)
+	return packet.Layer(layers.LayerTypeIPv6).LayerPayload()
+}
+
+func TestInterceptReassemblesFragments(t *testing.T) {
+	defer func(sniff bool) { sniffFlag = sniff }(sniffFlag)
+	sniffFlag = true
+
+	udp := largeResponse(t)
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// The last fragment may well arrive first
+	logs := interceptAll(
+		newFragment(t, 0xdead, 1200, udp[1200:], false, at),
+		newFragment(t, 0xdead, 0, udp[:1200], true, at),
+	)
+
+	if len(logs) != 1 {
+		t.Fatalf("exported %d logs, want 1", len(logs))
+	}
+	r, ok := logs[0].(*ResponseLog)
+	if !ok {
+		t.Fatalf("exported %T, want *ResponseLog", logs[0])
+	}
+	if r.QString != "big.example.com" || r.AnsTypes != "80xAAAA" {
+		t.Errorf("response for %s with %s, want big.example.com with 80xAAAA", r.QString, r.AnsTypes)
+	}
+}
+
+func TestDefragmenterGivesUp(t *testing.T) {
+	udp := largeResponse(t)
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t.Run("timeout", func(t *testing.T) {
+		d := newIP6Defragmenter(maxTTLCacheEntries)
+		defer d.close()
+		before := droppedFor(dropFragmentLost)
+		d.process(newFragment(t, 1, 0, udp[:1200], true, at))
+		late := at.Add(fragmentTimeout + time.Second)
+		if packet := d.process(newFragment(t, 1, 1200, udp[1200:], false, late)); packet != nil {
+			t.Error("reassembled a datagram whose first fragment was given up")
+		}
+		if n := droppedFor(dropFragmentLost) - before; n != 1 {
+			t.Errorf("counted %d datagrams given up, want 1", n)
+		}
+	})
+
+	t.Run("size", func(t *testing.T) {
+		d := newIP6Defragmenter(maxTTLCacheEntries)
+		defer d.close()
+		before, held := droppedFor(dropFragmentLost), droppedFor(dropFragmentHeld)
+		// The same fragment over and over never completes the datagram
+		chunk := make([]byte, 1232)
+		sent := 0
+		for ; sent*len(chunk) <= maxDatagramSize; sent++ {
+			d.process(newFragment(t, 2, 0, chunk, true, at))
+		}
+		if d.datagrams.len() != 0 {
+			t.Errorf("holding %d datagrams, want none", d.datagrams.len())
+		}
+		// The fragment making it too large is counted as lost only
+		if n := droppedFor(dropFragmentHeld) - held; n != uint64(sent-1) {
+			t.Errorf("counted %d fragments held, want %d", n, sent-1)
+		}
+		if n := droppedFor(dropFragmentLost) - before; n != 1 {
+			t.Errorf("counted %d datagrams given up, want 1", n)
+		}
+	})
+
+	t.Run("full", func(t *testing.T) {
+		d := newIP6Defragmenter(1)
+		defer d.close()
+		before := droppedFor(dropFragmentLost)
+		d.process(newFragment(t, 3, 0, udp[:1200], true, at))
+		d.process(newFragment(t, 4, 0, udp[:1200], true, at))
+		if packet := d.process(newFragment(t, 4, 1200, udp[1200:], false, at)); packet == nil {
+			t.Error("the datagram held last not reassembled")
+		}
+		if n := droppedFor(dropFragmentLost) - before; n != 1 {
+			t.Errorf("counted %d datagrams given up, want 1", n)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/gopacket"
+)
+
+// DoTLog records a connection attempt to DNS over TLS. Queries and responses
+// are encrypted, so the server name indication of the ClientHello, if any, is
+// the only clue of what the client is talking to.
+type DoTLog struct {
+	tableName struct{} `pg:"dot_logs"`
+	telescreenLogCommon
+	SNI string `pg:"sni" json:"sni"`
+}
+
+func (d *DoTLog) String() string {
+	ts := formatTime(d.Timestamp)
+	src := fmt.Sprintf("%s.%d", d.SrcIP.String(), d.SrcPort)
+	dst := fmt.Sprintf("%s.%d", d.DstIP.String(), d.DstPort)
+	return fmt.Sprintf("%s | %s%-43s > %-25s %s %-5s %-8s %s%s", ts, d.capturePoint(), src, dst, d.transportName(), "-", "SNI", d.SNI, d.fieldsString())
+}
+
+func (d *DoTLog) Colorize() string {
+	return fmt.Sprintf("\033[0;36m%s\033[0m", d.String())
+}
+
+// newDoTLog returns a log if the packet carries a TLS ClientHello to the DoT
+// port, otherwise nil.
+func newDoTLog(packet gopacket.Packet, c *telescreenLogCommon) *DoTLog {
+	transport := packet.TransportLayer()
+	if !c.TransTCP || c.DstPort != 853 || transport == nil {
+		return nil
+	}
+
+	sni, ok := parseClientHello(transport.LayerPayload())
+	if !ok {
+		return nil
+	}
+
+	d := new(DoTLog)
+	d.telescreenLogCommon = *c
+	d.Transport = transportDoT
+	d.setEndpoints(false)
+	d.SNI = sni
+	return d
+}
+
+// parseClientHello reports whether the data begins with a TLS ClientHello and
+// returns the server name it indicates. A ClientHello split across segments
+// is still reported, though the server name may be missing then.
+func parseClientHello(data []byte) (string, bool) {
+	// TLS record header: handshake, version, length
+	if len(data) < 5 || data[0] != 22 {
+		return "", false
+	}
+	data = data[5:]
+
+	// Handshake header: ClientHello, length
+	if len(data) < 4 || data[0] != 1 {
+		return "", false
+	}
+	data = data[4:]
+
+	// Skip the version, random, session ID, cipher suites and compression
+	// methods to reach the extensions
+	if len(data) < 35 {
+		return "", true
+	}
+	data = data[34:]
+	for _, lenSize := range []int{1, 2, 1} {
+		if len(data) < lenSize {
+			return "", true
+		}
+		n := int(data[0])
+		if lenSize == 2 {
+			n = int(binary.BigEndian.Uint16(data))
+		}
+		if len(data) < lenSize+n {
+			return "", true
+		}
+		data = data[lenSize+n:]
+	}
+
+	if len(data) < 2 {
+		return "", true
+	}
+	data = data[2:]
+	for len(data) >= 4 {
+		extType := binary.BigEndian.Uint16(data)
+		extLen := int(binary.BigEndian.Uint16(data[2:]))
+		data = data[4:]
+		if len(data) < extLen {
+			return "", true
+		}
+		if extType == 0 {
+			return parseServerName(data[:extLen]), true
+		}
+		data = data[extLen:]
+	}
+
+	return "", true
+}
+
+// parseServerName returns the host name in the server_name extension.
+func parseServerName(ext []byte) string {
+	if len(ext) < 2 {
+		return ""
+	}
+	list := ext[2:]
+	for len(list) >= 3 {
+		nameType := list[0]
+		nameLen := int(binary.BigEndian.Uint16(list[1:]))
+		list = list[3:]
+		if len(list) < nameLen {
+			return ""
+		}
+		if nameType == 0 {
+			return string(list[:nameLen])
+		}
+		list = list[nameLen:]
+	}
+	return ""
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// clientHello returns the first TLS record a client indicating the server
+// name sends.
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		defer client.Close()
+		tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: serverName == ""}).Handshake()
+	}()
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatal(err)
+	}
+	record := make([]byte, 5+int(header[3])<<8|int(header[4]))
+	copy(record, header)
+	if _, err := io.ReadFull(server, record[5:]); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestParseClientHello(t *testing.T) {
+	hello := clientHello(t, "dns.example.net")
+	if sni, ok := parseClientHello(hello); !ok || sni != "dns.example.net" {
+		t.Errorf("parseClientHello() = %q, %v, want dns.example.net", sni, ok)
+	}
+	if sni, ok := parseClientHello(clientHello(t, "")); !ok || sni != "" {
+		t.Errorf("parseClientHello() without SNI = %q, %v, want a ClientHello without a name", sni, ok)
+	}
+
+	// Split across segments, a ClientHello is still told apart from others
+	for i := 0; i < len(hello); i++ {
+		sni, ok := parseClientHello(hello[:i])
+		if ok != (i >= 9) {
+			t.Errorf("%d bytes taken for a ClientHello: %v", i, ok)
+		}
+		if sni != "" && sni != "dns.example.net" {
+			t.Errorf("%d bytes gave the name %q", i, sni)
+		}
+	}
+
+	malformed := map[string][]byte{
+		"not a handshake":      append([]byte{23}, hello[1:]...),
+		"not a ClientHello":    append(append([]byte{}, hello[:5]...), append([]byte{2}, hello[6:]...)...),
+		"overlong session ID":  overwrite(hello, 5+4+34, 0xff),
+		"overlong server name": overwriteServerNameLength(t, hello),
+	}
+	for name, data := range malformed {
+		if sni, _ := parseClientHello(data); sni == "dns.example.net" {
+			t.Errorf("%s: gave the name", name)
+		}
+	}
+}
+
+func overwrite(data []byte, i int, b byte) []byte {
+	data = append([]byte{}, data...)
+	data[i] = b
+	return data
+}
+
+// overwriteServerNameLength makes the name in the server_name extension claim
+// to run past the end of the extension.
+func overwriteServerNameLength(t *testing.T, hello []byte) []byte {
+	t.Helper()
+	i := bytes.Index(hello, []byte("dns.example.net"))
+	if i < 2 {
+		t.Fatal("server name not found")
+	}
+	data := overwrite(hello, i-2, 0xff)
+	return overwrite(data, i-1, 0xff)
+}
+
+// newTCPPacket builds a TCP segment carrying the payload from the client to
+// the server's port.
+func newTCPPacket(t *testing.T, dport uint16, payload []byte) gopacket.Packet {
+	t.Helper()
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, DstMAC: net.HardwareAddr{0, 1, 2, 3, 4, 6}, EthernetType: layers.EthernetTypeIPv6}
+	ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolTCP, SrcIP: net.ParseIP(testClient), DstIP: net.ParseIP(testServer)}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: layers.TCPPort(dport), PSH: true, ACK: true, Window: 65535}
+	tcp.SetNetworkLayerForChecksum(ip6)
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, eth, ip6, tcp, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+	return gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+}
+
+func TestDoTLog(t *testing.T) {
+	defer func(dot bool) { dotFlag = dot }(dotFlag)
+	dotFlag = true
+
+	d, ok := parsePacket(newTCPPacket(t, 853, clientHello(t, "dns.example.net"))).(*DoTLog)
+	if !ok {
+		t.Fatal("ClientHello to the DoT port not logged")
+	}
+	if d.SNI != "dns.example.net" || d.ClientIP.String() != testClient || d.ServerPort != 853 {
+		t.Errorf("DoT log = %s", d.String())
+	}
+}
+
+func TestTransport(t *testing.T) {
+	udp := newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA))
+	tcp := newTCPPacket(t, 53, []byte{0, 0})
+	dot := newTCPPacket(t, 853, clientHello(t, "dns.example.net"))
+
+	tests := []struct {
+		name      string
+		c         telescreenLogCommon
+		transport string
+		tcp       bool
+		rendered  string
+	}{
+		{"udp", *newTelescreenLogCommon(udp), transportUDP, false, "UDP"},
+		{"tcp", *newTelescreenLogCommon(tcp), transportTCP, true, "TCP"},
+		{"dot", newDoTLog(dot, newTelescreenLogCommon(dot)).telescreenLogCommon, transportDoT, true, "DoT"},
+	}
+	for _, tt := range tests {
+		if tt.c.Transport != tt.transport || tt.c.TransTCP != tt.tcp {
+			t.Errorf("%s: transport %q and TCP %v, want %q and %v", tt.name, tt.c.Transport, tt.c.TransTCP, tt.transport, tt.tcp)
+		}
+		if got := tt.c.transportName(); got != tt.rendered {
+			t.Errorf("%s: rendered as %s, want %s", tt.name, got, tt.rendered)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Reasons a packet produced no log, as labels of the metrics
+const (
+	dropDecodeError   string = "decode_error"
+	dropNoIPv6        string = "no_ipv6_layer"
+	dropNoTransport   string = "no_transport_layer"
+	dropFragmentHeld  string = "fragment_held"
+	dropFragmentLost  string = "fragment_lost"
+	dropNoDNS         string = "no_dns_layer"
+	dropNoQuestion    string = "no_question"
+	dropLabels        string = "qname_labels"
+	dropName          string = "qname_regex"
+	dropPort          string = "not_port_53"
+	dropResponse      string = "response_not_selected"
+	dropResponsesOnly string = "responses_only"
+	dropFirstSeen     string = "first_seen_interval"
+	dropSeenBefore    string = "seen_before"
+	dropAnswerCIDR    string = "answer_cidr"
+	dropRcode         string = "rcode"
+	dropEnricher      string = "enricher"
+)
+
+// Descriptions of the reasons shown by --trace
+var dropReasons = map[string]string{
+	dropDecodeError:   "failed to decode some part of the packet",
+	dropNoIPv6:        "no IPv6 layer",
+	dropNoTransport:   "no UDP or TCP layer",
+	dropFragmentHeld:  "fragment held until the rest of the datagram arrives",
+	dropFragmentLost:  "fragmented datagram given up before reassembly, e.g., incomplete for 30s or too large",
+	dropNoDNS:         "no DNS layer",
+	dropNoQuestion:    "no question",
+	dropLabels:        "number of labels out of --min-qname-labels and --max-qname-labels",
+	dropName:          "name not selected by --include-regex or --exclude-regex",
+	dropPort:          "neither port is 53",
+	dropResponse:      "response not selected by --capture-responses, --response-types or --drop-empty-response",
+	dropResponsesOnly: "query, with --responses-only",
+	dropFirstSeen:     "query repeated within --first-seen-interval",
+	dropSeenBefore:    "name seen before, with --only-new-domains",
+	dropAnswerCIDR:    "answer outside --answer-cidr",
+	dropRcode:         "response code not selected by --rcode",
+	dropEnricher:      "dropped by an enricher",
+}
+
+var (
+	dropsMu sync.Mutex
+	drops   = map[string]uint64{}
+)
+
+func countDrop(reason string) {
+	dropsMu.Lock()
+	defer dropsMu.Unlock()
+	drops[reason] += 1
+}
+
+type dropCount struct {
+	reason string
+	count  uint64
+}
+
+// dropCounts returns the packets dropped so far for each reason, sorted by it.
+func dropCounts() []dropCount {
+	dropsMu.Lock()
+	defer dropsMu.Unlock()
+
+	counts := make([]dropCount, 0, len(drops))
+	for reason, count := range drops {
+		counts = append(counts, dropCount{reason: reason, count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].reason < counts[j].reason })
+	return counts
+}
+
+// writeDropSummary writes the packets dropped for each reason, e.g., on exit.
+func writeDropSummary(w io.Writer) {
+	counts := dropCounts()
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Packets dropped without a log:")
+	for _, c := range counts {
+		fmt.Fprintf(w, "  %-22s %10d  %s\n", c.reason, c.count, dropReasons[c.reason])
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func droppedFor(reason string) uint64 {
+	for _, c := range dropCounts() {
+		if c.reason == reason {
+			return c.count
+		}
+	}
+	return 0
+}
+
+func TestDropCounterNonIP(t *testing.T) {
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, DstMAC: layers.EthernetBroadcast, EthernetType: layers.EthernetTypeARP}
+	arp := &layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   eth.SrcMAC,
+		SourceProtAddress: []byte{192, 0, 2, 1},
+		DstHwAddress:      make([]byte, 6),
+		DstProtAddress:    []byte{192, 0, 2, 53},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, eth, arp); err != nil {
+		t.Fatal(err)
+	}
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+
+	before := droppedFor(dropNoIPv6)
+	if l := parsePacket(packet); l != nil {
+		t.Fatalf("exported %T for an ARP packet", l)
+	}
+	if n := droppedFor(dropNoIPv6) - before; n != 1 {
+		t.Errorf("counted %d drops for %s, want 1", n, dropNoIPv6)
+	}
+
+	m := newLatencyMetrics(maxTTLCacheEntries)
+	defer m.pending.close()
+	var b bytes.Buffer
+	m.writePrometheus(&b)
+	if want := `telescreen_dropped_packets_total{reason="` + dropNoIPv6 + `"}`; !strings.Contains(b.String(), want) {
+		t.Errorf("metrics without %s:\n%s", want, b.String())
+	}
+}
+
+func TestWriteDropSummary(t *testing.T) {
+	countDrop(dropNoQuestion)
+	var b bytes.Buffer
+	writeDropSummary(&b)
+	if !strings.HasPrefix(b.String(), "Packets dropped without a log:\n") || !strings.Contains(b.String(), dropReasons[dropNoQuestion]) {
+		t.Errorf("summary = %q, want the reason described", b.String())
+	}
+}
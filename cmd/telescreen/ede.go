@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/gopacket/layers"
+)
+
+const ednsOptionEDE layers.DNSOptionCode = 15 // Extended DNS Error, unknown to gopacket
+
+// Info codes of Extended DNS Errors registered by RFC 8914
+var edeNames = map[uint16]string{
+	0:  "Other Error",
+	1:  "Unsupported DNSKEY Algorithm",
+	2:  "Unsupported DS Digest Type",
+	3:  "Stale Answer",
+	4:  "Forged Answer",
+	5:  "DNSSEC Indeterminate",
+	6:  "DNSSEC Bogus",
+	7:  "Signature Expired",
+	8:  "Signature Not Yet Valid",
+	9:  "DNSKEY Missing",
+	10: "RRSIGs Missing",
+	11: "No Zone Key Bit Set",
+	12: "NSEC Missing",
+	13: "Cached Error",
+	14: "Not Ready",
+	15: "Blocked",
+	16: "Censored",
+	17: "Filtered",
+	18: "Prohibited",
+	19: "Stale NXDomain Answer",
+	20: "Not Authoritative",
+	21: "Not Supported",
+	22: "No Reachable Authority",
+	23: "Network Error",
+	24: "Invalid Data",
+}
+
+// extendedError is an Extended DNS Error telling why a resolver failed or
+// altered the response, e.g., blocked by a policy.
+type extendedError struct {
+	InfoCode  uint16 `json:"info_code"`
+	ExtraText string `json:"extra_text,omitempty"`
+}
+
+func (e extendedError) String() string {
+	s := fmt.Sprintf("EDE %d", e.InfoCode)
+	if name, ok := edeNames[e.InfoCode]; ok {
+		s += " (" + name + ")"
+	}
+	if e.ExtraText != "" {
+		s += ": " + e.ExtraText
+	}
+	return s
+}
+
+// extendedErrors returns the Extended DNS Error options of the OPT record, as
+// many as the response carries.
+func extendedErrors(dns *layers.DNS) []extendedError {
+	var errors []extendedError
+	for _, record := range dns.Additionals {
+		if record.Type != layers.DNSTypeOPT {
+			continue
+		}
+		for _, option := range record.OPT {
+			if option.Code != ednsOptionEDE || len(option.Data) < 2 {
+				continue
+			}
+			errors = append(errors, extendedError{
+				InfoCode:  binary.BigEndian.Uint16(option.Data),
+				ExtraText: string(option.Data[2:]),
+			})
+		}
+	}
+	return errors
+}
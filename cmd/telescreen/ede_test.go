@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestResponseExtendedErrors(t *testing.T) {
+	defer func(capture bool) { captureFlag = capture }(captureFlag)
+	captureFlag = true
+
+	dns := response("ads.example.com", layers.DNSTypeA)
+	dns.ResponseCode = layers.DNSResponseCodeRefused
+	dns.Additionals = []layers.DNSResourceRecord{{Type: layers.DNSTypeOPT, Class: 1232, OPT: []layers.DNSOPT{
+		{Code: ednsOptionEDE, Data: append([]byte{0, 15}, "blocked by policy"...)},
+		{Code: ednsOptionEDE, Data: []byte{0, 18}},
+	}}}
+	r, ok := parsePacket(newResponsePacket(t, dns, time.Millisecond)).(*ResponseLog)
+	if !ok {
+		t.Fatal("no response logged")
+	}
+
+	want := []extendedError{{InfoCode: 15, ExtraText: "blocked by policy"}, {InfoCode: 18}}
+	if len(r.ExtErrors) != len(want) {
+		t.Fatalf("ExtErrors = %v, want %v", r.ExtErrors, want)
+	}
+	for i := range want {
+		if r.ExtErrors[i] != want[i] {
+			t.Errorf("ExtErrors[%d] = %v, want %v", i, r.ExtErrors[i], want[i])
+		}
+	}
+	if s := r.String(); !strings.Contains(s, "EDE 15 (Blocked): blocked by policy") || !strings.Contains(s, "EDE 18 (Prohibited)") {
+		t.Errorf("String() = %q, want both EDEs", s)
+	}
+}
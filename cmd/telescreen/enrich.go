@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/wide-vsix/telescreen/enrich"
+)
+
+// runEnrichers runs the enrichers registered with the enrich package on the
+// log, and returns nil if one of them drops it. The fields they add are kept
+// in the log for the exporters.
+func runEnrichers(packet gopacket.Packet, qr telescreenLog) telescreenLog {
+	if enrich.Len() == 0 {
+		return qr
+	}
+	c := logCommon(qr)
+	r := &enrich.Record{
+		Timestamp:  c.Timestamp,
+		Transport:  c.Transport,
+		ClientIP:   c.ClientIP,
+		ClientPort: c.ClientPort,
+		ServerIP:   c.ServerIP,
+		ServerPort: c.ServerPort,
+		SourceHost: c.SourceHost,
+		Fields:     map[string]string{},
+	}
+	for k, v := range c.Fields {
+		r.Fields[k] = v
+	}
+	switch log := qr.(type) {
+	case *QueryLog:
+		r.QueryName, r.QueryType = log.QString, log.QType
+	case *ResponseLog:
+		r.QueryName, r.QueryType = log.QString, log.QType
+		r.Response, r.AnswerIP = true, log.AnsIP
+	case *DoTLog:
+		r.SNI = log.SNI
+	}
+
+	if r = enrich.Run(packet, r); r == nil {
+		return nil
+	}
+	c.Fields = nil
+	if len(r.Fields) > 0 {
+		c.Fields = r.Fields
+	}
+	return qr
+}
+
+// fieldsString shows the fields added by enrichers, e.g., " {asn=64496,
+// site=tokyo}", or nothing without them.
+func (c *telescreenLogCommon) fieldsString() string {
+	if len(c.Fields) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(c.Fields))
+	for k := range c.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=%s", k, c.Fields[k])
+	}
+	return " {" + strings.Join(pairs, ", ") + "}"
+}
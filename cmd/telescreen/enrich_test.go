@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/wide-vsix/telescreen/enrich"
+)
+
+// testSite is what the enricher registered below tags logs with, and it
+// does nothing while testSite is empty so that other tests are not affected.
+var testSite string
+
+func init() {
+	enrich.Register(func(packet gopacket.Packet, r *enrich.Record) *enrich.Record {
+		switch {
+		case testSite == "":
+		case r.QueryName == "noise.example.com":
+			return nil
+		default:
+			r.Fields["site"] = testSite
+			if r.SourceHost != "" {
+				r.Fields["agent"] = r.SourceHost
+			}
+		}
+		return r
+	})
+}
+
+func TestEnricherFieldsReachExporters(t *testing.T) {
+	defer func(site string) { testSite = site }(testSite)
+	testSite = "tokyo"
+
+	logs := interceptAll(
+		newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA)),
+		newQueryPacket(t, query("noise.example.com", layers.DNSTypeAAAA)),
+	)
+	if len(logs) != 1 {
+		t.Fatalf("got %d logs, want 1 as the other is dropped", len(logs))
+	}
+	l := logs[0]
+	if got, want := logCommon(l).Fields["site"], "tokyo"; got != want {
+		t.Errorf("got site %q, want %q", got, want)
+	}
+
+	dir := t.TempDir()
+	jsonPath, textPath := filepath.Join(dir, "out.json"), filepath.Join(dir, "out.log")
+	jsonExporter, jsonCloser, err := newFileExporter(jsonPath, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	textExporter, textCloser, err := newFileExporter(textPath, "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonExporter(l)
+	textExporter(l)
+	jsonCloser()
+	textCloser()
+
+	b, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record struct {
+		Fields map[string]string `json:"fields"`
+	}
+	if err := json.Unmarshal(b, &record); err != nil || record.Fields["site"] != "tokyo" {
+		t.Errorf("JSON log file = %q, want the site", b)
+	}
+	if b, err := os.ReadFile(textPath); err != nil || !strings.Contains(string(b), " {site=tokyo}") {
+		t.Errorf("text log file = %q, want the site", b)
+	}
+	if got := newDnsEvent(l).Fields["site"]; got != "tokyo" {
+		t.Errorf("got site %q in the event, want tokyo", got)
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Exporters may be called from multiple goroutines, so each of them must
+// serialize access to its own destination.
+
+// Policies of --overflow-policy, deciding what an exporter queue sacrifices
+// when it is full
+const (
+	overflowBlock      string = "block"       // Wait for room, stalling the capture until the kernel drops packets
+	overflowDropNewest string = "drop-newest" // Drop the log being queued
+	overflowDropOldest string = "drop-oldest" // Drop the log queued the longest to make room
+)
+
+// exportQueue is the queue in front of an isolated exporter.
+type exportQueue struct {
+	name    string
+	policy  string
+	dropped uint64
+}
+
+var (
+	exportQueuesMu sync.Mutex
+	exportQueues   []*exportQueue
+)
+
+type exportQueueStats struct {
+	name    string
+	policy  string
+	dropped uint64
+}
+
+// allExportQueueStats returns the logs dropped by each exporter queue, sorted
+// by the name of the exporter.
+func allExportQueueStats() []exportQueueStats {
+	exportQueuesMu.Lock()
+	defer exportQueuesMu.Unlock()
+
+	stats := make([]exportQueueStats, len(exportQueues))
+	for i, q := range exportQueues {
+		stats[i] = exportQueueStats{name: q.name, policy: q.policy, dropped: atomic.LoadUint64(&q.dropped)}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].name < stats[j].name })
+	return stats
+}
+
+// isolateExporter runs the exporter on its own goroutine behind a bounded queue,
+// so that a slow or failing exporter does not stall the capture and the other
+// exporters, unless the policy is to block when the queue is full. Otherwise it
+// drops either the new log or the oldest queued one. The returned function
+// waits until the queued logs are exported, and must be called after the
+// capture ends.
+func isolateExporter(name string, exporter func(qr telescreenLog), size int, policy string) (func(qr telescreenLog), func()) {
+	queue := make(chan telescreenLog, size)
+	done := make(chan struct{})
+	q := &exportQueue{name: name, policy: policy}
+	exportQueuesMu.Lock()
+	exportQueues = append(exportQueues, q)
+	exportQueuesMu.Unlock()
+
+	go func() {
+		defer close(done)
+		for qr := range queue {
+			exporter(qr)
+		}
+	}()
+
+	drop := func() {
+		if atomic.AddUint64(&q.dropped, 1) == 1 {
+			fmt.Fprintf(os.Stderr, "The %s exporter cannot keep up, dropping logs\n", name)
+		}
+	}
+
+	enqueue := func(qr telescreenLog) {
+		switch policy {
+		case overflowBlock:
+			queue <- qr
+		case overflowDropOldest:
+			for {
+				select {
+				case queue <- qr:
+					return
+				default:
+				}
+				// The exporter may have taken it meanwhile, leaving room
+				select {
+				case <-queue:
+					drop()
+				default:
+				}
+			}
+		default:
+			select {
+			case queue <- qr:
+			default:
+				drop()
+			}
+		}
+	}
+
+	drain := func() {
+		close(queue)
+		<-done
+		if n := atomic.LoadUint64(&q.dropped); n > 0 {
+			fmt.Fprintf(os.Stderr, "Dropped %d logs for the %s exporter\n", n, name)
+		}
+	}
+
+	return enqueue, drain
+}
+
+// explodeAnswers passes a response answering several addresses to the exporter
+// once for each of them, so that the lines differ only in the answer.
+func explodeAnswers(exporter func(qr telescreenLog)) func(qr telescreenLog) {
+	return func(qr telescreenLog) {
+		r, ok := qr.(*ResponseLog)
+		if !ok || len(r.answerIPs) < 2 {
+			exporter(qr)
+			return
+		}
+		for _, ip := range r.answerIPs {
+			each := *r
+			each.AnsIP = ip
+			each.IPv6Ready = !nat64Prefix.Contains(ip)
+			exporter(&each)
+		}
+	}
+}
+
+func stdExporter(qr telescreenLog) {
+	if qr != nil {
+		// A single write to os.Stdout is atomic, no need to lock
+		fmt.Println(qr.Colorize())
+	}
+}
+
+// newBufferedStdExporter prints like stdExporter but through a buffer flushed
+// every interval, saving a write per log at high rates.
+func newBufferedStdExporter(interval time.Duration) (func(qr telescreenLog), func()) {
+	var mu sync.Mutex
+	w := bufio.NewWriter(os.Stdout)
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				mu.Lock()
+				w.Flush()
+				mu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	exporter := func(qr telescreenLog) {
+		if qr == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintln(w, qr.Colorize())
+	}
+
+	closer := func() {
+		ticker.Stop()
+		close(done)
+		mu.Lock()
+		defer mu.Unlock()
+		w.Flush()
+	}
+
+	return exporter, closer
+}
+
+// newBenchExporter discards logs but counts them, reporting the throughput of
+// the parsing path on close.
+func newBenchExporter() (func(qr telescreenLog), func()) {
+	var count uint64
+	start := time.Now()
+
+	exporter := func(qr telescreenLog) {
+		atomic.AddUint64(&count, 1)
+	}
+
+	closer := func() {
+		n := atomic.LoadUint64(&count)
+		elapsed := time.Since(start)
+		fmt.Fprintf(os.Stderr, "Bench sink received %d logs in %v (%.1f logs/s)\n", n, elapsed, float64(n)/elapsed.Seconds())
+	}
+
+	return exporter, closer
+}
+
+func newFileExporter(path string, format string) (func(qr telescreenLog), func(), error) {
+	var encode func(f *os.File, qr telescreenLog) error
+	var header []string
+	switch format {
+	case "text":
+		encode = func(f *os.File, qr telescreenLog) error {
+			_, err := fmt.Fprintln(f, qr.String())
+			return err
+		}
+	case "json":
+		encode = func(f *os.File, qr telescreenLog) error {
+			b, err := json.Marshal(qr)
+			if err != nil {
+				return err
+			}
+			_, err = f.Write(append(b, '\n'))
+			return err
+		}
+	case "csv":
+		header = csvHeader
+		encode = func(f *os.File, qr telescreenLog) error {
+			record := csvRecord(qr)
+			if record == nil {
+				return nil
+			}
+			w := csv.NewWriter(f)
+			w.Write(record)
+			w.Flush()
+			return w.Error()
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown log file format: %s", format)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A file appended to by an earlier run has the header already
+	if info, err := f.Stat(); err == nil && info.Size() == 0 && header != nil {
+		w := csv.NewWriter(f)
+		w.Write(header)
+		w.Flush()
+		if err := w.Error(); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	}
+
+	var mu sync.Mutex
+	exporter := func(qr telescreenLog) {
+		if qr == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err := encode(f, qr); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write log file: %v\n", err)
+		}
+	}
+
+	closer := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		f.Close()
+	}
+
+	return exporter, closer, nil
+}
+
+// Columns of --format csv
+var csvHeader = []string{"timestamp", "src_ip", "src_port", "dst_ip", "dst_port", "transport", "query_type", "query_string", "answer_ip", "fields"}
+
+// csvRecord flattens a log into the columns of csvHeader. The answer is left
+// empty for a query, and a DoT connection is written as an SNI query like the
+// text does. The fields added by enrichers are a JSON object, or empty without
+// them. It returns nil for logs other than those defined here.
+func csvRecord(qr telescreenLog) []string {
+	c := logCommon(qr)
+	if c == nil {
+		return nil
+	}
+	record := []string{
+		c.Timestamp.Format(time.RFC3339Nano),
+		ipString(c.SrcIP),
+		strconv.Itoa(int(c.SrcPort)),
+		ipString(c.DstIP),
+		strconv.Itoa(int(c.DstPort)),
+		c.Transport,
+	}
+	switch log := qr.(type) {
+	case *QueryLog:
+		record = append(record, log.QType, log.QString, "")
+	case *ResponseLog:
+		record = append(record, log.QType, log.QString, ipString(log.AnsIP))
+	case *CombinedLog:
+		record = append(record, log.QType, log.QString, ipString(log.AnsIP))
+	case *DoTLog:
+		record = append(record, "SNI", log.SNI, "")
+	default:
+		return nil
+	}
+
+	fields := ""
+	if len(c.Fields) > 0 {
+		// Never fails for strings
+		b, _ := json.Marshal(c.Fields)
+		fields = string(b)
+	}
+	return append(record, fields)
+}
+
+// newProtobufExporter appends logs to the file as DnsEvent messages of the gRPC
+// API, each prefixed with its length in a varint. A run of failed writes gives
+// up the program.
+func newProtobufExporter(path string) (func(qr telescreenLog), func(), error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	w := bufio.NewWriter(f)
+
+	var mu sync.Mutex
+	budget := newFailureBudget("protobuf file")
+	exporter := func(qr telescreenLog) {
+		event := newDnsEvent(qr)
+		if event == nil || budget.exhausted() {
+			return
+		}
+		b, err := proto.Marshal(event)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode protobuf: %v\n", err)
+			return
+		}
+		// Written at once, so that a failure never leaves the length
+		// prefix without its message
+		record := protowire.AppendVarint(make([]byte, 0, protowire.SizeVarint(uint64(len(b)))+len(b)), uint64(len(b)))
+		record = append(record, b...)
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err := w.Write(record); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write protobuf file: %v\n", err)
+			budget.fail()
+			return
+		}
+		budget.succeed()
+	}
+
+	closer := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := w.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write protobuf file: %v\n", err)
+		}
+		f.Close()
+	}
+
+	return exporter, closer, nil
+}
+
+// newUnixSocketExporter writes logs as JSON lines to the Unix domain socket at
+// the path, which a local collector must be listening on. The socket is
+// connected again after a failed write, at most once a second, dropping the
+// logs in between. A run of failed writes gives up the program.
+func newUnixSocketExporter(path string) (func(qr telescreenLog), func(), error) {
+	conn, err := net.DialTimeout("unix", path, unixSocketTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var mu sync.Mutex
+	var lastDial time.Time
+	budget := newFailureBudget("Unix socket")
+	exporter := func(qr telescreenLog) {
+		if budget.exhausted() {
+			return
+		}
+		b, err := json.Marshal(qr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode JSON: %v\n", err)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if conn == nil {
+			if time.Since(lastDial) < time.Second {
+				return
+			}
+			lastDial = time.Now()
+			if conn, err = net.DialTimeout("unix", path, unixSocketTimeout); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to reconnect to Unix socket: %v\n", err)
+				budget.fail()
+				return
+			}
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(unixSocketTimeout))
+		if _, err := conn.Write(append(b, '\n')); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write to Unix socket: %v\n", err)
+			conn.Close()
+			conn = nil
+			budget.fail()
+			return
+		}
+		budget.succeed()
+	}
+
+	closer := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if conn != nil {
+			conn.Close()
+			conn = nil
+		}
+	}
+
+	return exporter, closer, nil
+}
+
+const maxExporterFailures int = 5 // In a row, after which the program gives up
+
+var exporterGaveUp uint32 // Set atomically once any exporter gives up
+
+// failureBudget counts the failures of an exporter in a row. Once they exceed
+// maxExporterFailures, the exporter gives up and the run is stopped as a signal
+// does, so that the other exporters are still closed cleanly, and the program
+// exits with 1.
+type failureBudget struct {
+	mu       sync.Mutex
+	problem  string
+	failures int
+}
+
+func newFailureBudget(problem string) *failureBudget {
+	return &failureBudget{problem: problem}
+}
+
+func (b *failureBudget) fail() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures += 1
+	if b.failures == maxExporterFailures+1 {
+		fmt.Fprintf(os.Stderr, "Exit with %s problem\n", b.problem)
+		atomic.StoreUint32(&exporterGaveUp, 1)
+		stopRun()
+	}
+}
+
+func (b *failureBudget) succeed() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures <= maxExporterFailures {
+		b.failures = 0
+	}
+}
+
+// exhausted reports whether the exporter gave up, and should not even try
+// while the run stops.
+func (b *failureBudget) exhausted() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures > maxExporterFailures
+}
+
+// newDBExporter stores logs through the backend. Failing inserts are reported
+// and the program gives up after a run of them, whatever the backend is.
+func newDBExporter(backend dbBackend) (func(qr telescreenLog), func()) {
+	budget := newFailureBudget("DB connection")
+	for _, schema := range schemas {
+		if err := backend.createTable(schema); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to prepare table: %v\n", err)
+		}
+	}
+
+	exporter := func(qr telescreenLog) {
+		if budget.exhausted() {
+			return
+		}
+		if err := backend.insert(qr); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to issue INSERT: %v\n", err)
+			budget.fail()
+			return
+		}
+		budget.succeed()
+	}
+
+	closer := func() {
+		fmt.Println("Closing database connection...")
+		backend.close()
+	}
+
+	return exporter, closer
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/wide-vsix/telescreen/telescreenpb"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func newTestCommon() telescreenLogCommon {
+	return telescreenLogCommon{
+		Timestamp:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		SrcIP:      net.ParseIP(testClient),
+		DstIP:      net.ParseIP(testServer),
+		SrcPort:    40000,
+		DstPort:    53,
+		Transport:  transportUDP,
+		ClientIP:   net.ParseIP(testClient),
+		ClientPort: 40000,
+		ServerIP:   net.ParseIP(testServer),
+		ServerPort: 53,
+	}
+}
+
+// readLines reads back the lines an exporter wrote.
+func readLines(t *testing.T, r io.Reader) []string {
+	t.Helper()
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Error(err)
+	}
+	return lines
+}
+
+func TestExportersReceiveAll(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath, textPath := filepath.Join(dir, "out.json"), filepath.Join(dir, "out.log")
+	jsonExporter, jsonCloser, err := newFileExporter(jsonPath, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	textExporter, textCloser, err := newFileExporter(textPath, "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The standard output is read through a pipe
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(f *os.File) { os.Stdout = f }(os.Stdout)
+	os.Stdout = w
+	stdout := make(chan []string)
+	go func() { stdout <- readLines(t, r) }()
+
+	// Wired as main does, and called from several goroutines at once
+	exporters := []func(telescreenLog){stdExporter, jsonExporter, textExporter}
+	q := &QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.com", QType: "AAAA"}
+	const records = 10
+	var wg sync.WaitGroup
+	for i := 0; i < records; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, exporter := range exporters {
+				exporter(q)
+			}
+		}()
+	}
+	wg.Wait()
+	jsonCloser()
+	textCloser()
+	w.Close()
+
+	if lines := <-stdout; len(lines) != records || !strings.Contains(lines[0], "www.example.com") {
+		t.Errorf("standard output = %q, want %d lines of the query", lines, records)
+	}
+	f, err := os.Open(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := readLines(t, f)
+	if len(lines) != records {
+		t.Errorf("JSON log file has %d lines, want %d", len(lines), records)
+	}
+	for _, line := range lines {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil || record["query_string"] != "www.example.com" {
+			t.Errorf("JSON log file line = %q", line)
+		}
+	}
+	b, err := os.ReadFile(textPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), q.String()+"\n"); n != records {
+		t.Errorf("text log file has %d lines of the query, want %d", n, records)
+	}
+}
+
+func TestSlowExporterDoesNotStallOthers(t *testing.T) {
+	release := make(chan struct{})
+	var slowCount, fastCount uint64
+	slow := func(qr telescreenLog) {
+		<-release
+		atomic.AddUint64(&slowCount, 1)
+	}
+	fast := func(qr telescreenLog) { atomic.AddUint64(&fastCount, 1) }
+	slowExporter, slowDrain := isolateExporter("slow", slow, 4, overflowDropNewest)
+	fastExporter, fastDrain := isolateExporter("fast", fast, 1024, overflowDropNewest)
+
+	const records = 100
+	q := &QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.com", QType: "AAAA"}
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for i := 0; i < records; i++ {
+			slowExporter(q)
+			fastExporter(q)
+		}
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("exporting blocked by the slow exporter")
+	}
+	fastDrain()
+	if n := atomic.LoadUint64(&fastCount); n != records {
+		t.Errorf("fast exporter received %d logs, want %d", n, records)
+	}
+
+	close(release)
+	slowDrain()
+	if n := atomic.LoadUint64(&slowCount); n == 0 || n >= records {
+		t.Errorf("slow exporter received %d logs, want its queue and no more", n)
+	}
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		policy  string
+		want    string
+		dropped uint64
+	}{
+		{overflowBlock, "0,1,2,3,4,5,6,7,8,9", 0},
+		{overflowDropNewest, "0,1,2,3,4", 5},
+		{overflowDropOldest, "0,6,7,8,9", 5},
+	}
+	for _, tt := range tests {
+		release, taken := make(chan struct{}), make(chan struct{}, 1)
+		var names []string
+		sink := func(qr telescreenLog) {
+			select {
+			case taken <- struct{}{}:
+			default:
+			}
+			<-release
+			names = append(names, qr.(*QueryLog).QString)
+		}
+		name := "saturated " + tt.policy
+		exporter, drain := isolateExporter(name, sink, 4, tt.policy)
+		send := func(i int) {
+			exporter(&QueryLog{telescreenLogCommon: newTestCommon(), QString: strconv.Itoa(i), QType: "AAAA"})
+		}
+
+		// The sink holds the first log, and the queue of 4 fills up behind it
+		send(0)
+		<-taken
+		sent := make(chan struct{})
+		go func() {
+			defer close(sent)
+			for i := 1; i < 10; i++ {
+				send(i)
+			}
+		}()
+		select {
+		case <-sent:
+			if tt.policy == overflowBlock {
+				t.Errorf("%s: queued all the logs for a saturated sink, want blocking", tt.policy)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if tt.policy != overflowBlock {
+				t.Errorf("%s: blocked on a saturated sink", tt.policy)
+			}
+		}
+		close(release)
+		<-sent
+		drain()
+
+		if got := strings.Join(names, ","); got != tt.want {
+			t.Errorf("%s: exported %s, want %s", tt.policy, got, tt.want)
+		}
+		for _, s := range allExportQueueStats() {
+			if s.name == name && (s.dropped != tt.dropped || s.policy != tt.policy) {
+				t.Errorf("%s: counted %d dropped under %s, want %d", tt.policy, s.dropped, s.policy, tt.dropped)
+			}
+		}
+	}
+}
+
+// readDnsEvents reads back the length-delimited messages --protobuf-out wrote.
+func readDnsEvents(t *testing.T, path string) []*telescreenpb.DnsEvent {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []*telescreenpb.DnsEvent
+	for len(b) > 0 {
+		size, n := protowire.ConsumeVarint(b)
+		if n < 0 || uint64(len(b)-n) < size {
+			t.Fatalf("truncated message after %d events", len(events))
+		}
+		event := new(telescreenpb.DnsEvent)
+		if err := proto.Unmarshal(b[n:n+int(size)], event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+		b = b[n+int(size):]
+	}
+	return events
+}
+
+func TestProtobufExporterRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.pb")
+	exporter, closer, err := newProtobufExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := &QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.com", QType: "AAAA", TransID: 0x1234, Opcode: "QUERY"}
+	r := &ResponseLog{QueryLog: *q, AnsIP: net.ParseIP("2001:db8::80"), IPv6Ready: true, AnsTypes: "1xAAAA", AnsSection: "ANSWER"}
+	r.SrcIP, r.DstIP, r.SrcPort, r.DstPort = q.DstIP, q.SrcIP, q.DstPort, q.SrcPort
+	d := &DoTLog{telescreenLogCommon: newTestCommon(), SNI: "dns.example.net"}
+	d.DstPort, d.ServerPort, d.Transport = 853, 853, transportDoT
+	for _, l := range []telescreenLog{q, r, d} {
+		exporter(l)
+	}
+	closer()
+
+	events := readDnsEvents(t, path)
+	if len(events) != 3 {
+		t.Fatalf("read %d events, want 3", len(events))
+	}
+	query, resp, dot := events[0], events[1], events[2]
+
+	if query.Response || query.QueryString != "www.example.com" || query.QueryType != "AAAA" || query.TransactionId != 0x1234 || query.Opcode != "QUERY" || query.SrcIp != testClient || query.DstPort != 53 || query.Transport != transportUDP {
+		t.Errorf("query = %v", query)
+	}
+	if !query.ReceivedAt.AsTime().Equal(q.Timestamp) {
+		t.Errorf("query received at %v, want %v", query.ReceivedAt.AsTime(), q.Timestamp)
+	}
+	if !resp.Response || resp.AnswerIp != "2001:db8::80" || !resp.Ipv6Ready || resp.AnswerTypes != "1xAAAA" || resp.AnswerSection != "ANSWER" || resp.SrcIp != testServer || resp.ClientIp != testClient {
+		t.Errorf("response = %v", resp)
+	}
+	if dot.Sni != "dns.example.net" || dot.Transport != transportDoT || dot.ServerPort != 853 || dot.QueryString != "" || dot.Response {
+		t.Errorf("DoT = %v", dot)
+	}
+}
+
+func TestMatchFilterSNI(t *testing.T) {
+	d := &DoTLog{telescreenLogCommon: newTestCommon(), SNI: "dns.example.net"}
+	event := newDnsEvent(d)
+	if !matchFilter(&telescreenpb.Filter{DomainSuffixes: []string{"example.net"}}, event) {
+		t.Error("SNI not matched by its domain suffix")
+	}
+	if matchFilter(&telescreenpb.Filter{DomainSuffixes: []string{"example.com"}}, event) {
+		t.Error("SNI matched by another domain suffix")
+	}
+}
+
+func TestExplodeAnswers(t *testing.T) {
+	dns := response("www.example.com", layers.DNSTypeAAAA,
+		aaaa("www.example.com", "2001:db8::80", 300),
+		aaaa("www.example.com", "2001:db8::81", 300),
+		aaaa("www.example.com", "64:ff9b::c000:203", 300),
+	)
+	packet := newResponsePacket(t, dns, 0)
+	r := newResponseLog(packet, newQueryLog(packet, newTelescreenLogCommon(packet)))
+	if r == nil {
+		t.Fatal("response not parsed")
+	}
+
+	var lines []string
+	exporter := explodeAnswers(func(l telescreenLog) { lines = append(lines, l.String()) })
+	exporter(r)
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3: %q", len(lines), lines)
+	}
+	// Nothing but the answer differs
+	for i, ip := range []string{"2001:db8::80", "2001:db8::81", "64:ff9b::c000:203"} {
+		if want := strings.Replace(lines[0], "(2001:db8::80)", "("+ip+")", 1); lines[i] != want {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want)
+		}
+	}
+	if r.AnsIP.String() != "2001:db8::80" {
+		t.Errorf("response changed to %v", r.AnsIP)
+	}
+
+	// Logs other than a response with several addresses pass as they are
+	lines = nil
+	exporter(&ResponseLog{QueryLog: QueryLog{telescreenLogCommon: newTestCommon()}, AnsIP: net.ParseIP("2001:db8::80")})
+	exporter(&QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.com", QType: "AAAA"})
+	if len(lines) != 2 {
+		t.Errorf("got %d lines, want 2", len(lines))
+	}
+}
+
+type fakeBackend struct {
+	err     error
+	inserts int
+}
+
+func (b *fakeBackend) createTable(schema interface{}) error { return nil }
+func (b *fakeBackend) close() error                         { return nil }
+
+func (b *fakeBackend) insert(qr telescreenLog) error {
+	b.inserts += 1
+	return b.err
+}
+
+// resetShutdown lets the run be stopped again after the test.
+func resetShutdown() {
+	shutdown, shutdownOnce = make(chan struct{}), sync.Once{}
+	atomic.StoreUint32(&exporterGaveUp, 0)
+}
+
+func TestFailureBudgetPerExporter(t *testing.T) {
+	defer resetShutdown()
+	q := &QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.com", QType: "AAAA"}
+
+	// Successes of a healthy exporter do not hide the failures of another
+	failing, healthy := &fakeBackend{err: errors.New("connection refused")}, &fakeBackend{}
+	failingExporter, _ := newDBExporter(failing)
+	healthyExporter, _ := newDBExporter(healthy)
+	for i := 0; i < maxExporterFailures; i++ {
+		failingExporter(q)
+		healthyExporter(q)
+	}
+	select {
+	case <-shutdown:
+		t.Fatal("stopped within the budget")
+	default:
+	}
+
+	failingExporter(q)
+	select {
+	case <-shutdown:
+	default:
+		t.Fatal("not stopped over the budget")
+	}
+	if atomic.LoadUint32(&exporterGaveUp) == 0 {
+		t.Error("giving up not recorded for the exit code")
+	}
+
+	// Logs still queued are not even tried while the run stops
+	failingExporter(q)
+	if failing.inserts != maxExporterFailures+1 {
+		t.Errorf("tried %d inserts, want %d", failing.inserts, maxExporterFailures+1)
+	}
+	healthyExporter(q)
+	if healthy.inserts != maxExporterFailures+1 {
+		t.Errorf("healthy exporter tried %d inserts, want %d", healthy.inserts, maxExporterFailures+1)
+	}
+}
+
+func TestFailureBudgetResetsOnSuccess(t *testing.T) {
+	defer resetShutdown()
+	budget := newFailureBudget("test")
+	for i := 0; i < 3*maxExporterFailures; i++ {
+		budget.fail()
+		if i%maxExporterFailures == maxExporterFailures-1 {
+			budget.succeed()
+		}
+	}
+	if budget.exhausted() {
+		t.Error("gave up on failures not in a row")
+	}
+}
+
+func TestProtobufWriteFailureGivesUp(t *testing.T) {
+	defer resetShutdown()
+	exporter, closer, err := newProtobufExporter("/dev/full")
+	if err != nil {
+		t.Skipf("no /dev/full to fail writes: %v", err)
+	}
+	defer closer()
+
+	// Writes fail once the buffer is flushed, and keep failing after that
+	q := &QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.com", QType: "AAAA"}
+	for i := 0; i < 1000; i++ {
+		exporter(q)
+	}
+	select {
+	case <-shutdown:
+	default:
+		t.Fatal("not stopped on failed writes")
+	}
+	if atomic.LoadUint32(&exporterGaveUp) == 0 {
+		t.Error("giving up not recorded for the exit code")
+	}
+}
+
+func TestUnixSocketExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collector.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conns := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conns <- conn
+		}
+	}()
+
+	exporter, closer, err := newUnixSocketExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter(&QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.com", QType: "AAAA"})
+	exporter(&DoTLog{telescreenLogCommon: newTestCommon(), SNI: "dns.example.net"})
+	closer()
+
+	conn := <-conns
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	scanner := bufio.NewScanner(conn)
+	var records []map[string]interface{}
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("not a JSON line: %q", scanner.Text())
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("read %d records, want 2", len(records))
+	}
+	if records[0]["query_string"] != "www.example.com" || records[1]["sni"] != "dns.example.net" {
+		t.Errorf("records = %v", records)
+	}
+}
+
+func TestCSVExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.csv")
+	q := &QueryLog{telescreenLogCommon: newTestCommon(), QString: `odd,"name".example.com`, QType: "AAAA"}
+	q.Fields = map[string]string{"site": "tokyo"}
+	r := &ResponseLog{QueryLog: QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.com", QType: "AAAA"}, AnsIP: net.ParseIP("2001:db8::80")}
+
+	// Appending in another run does not repeat the header
+	for _, l := range []telescreenLog{q, r} {
+		exporter, closer, err := newFileExporter(path, "csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		exporter(l)
+		closer()
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		csvHeader,
+		{"2024-01-01T00:00:00Z", testClient, "40000", testServer, "53", "udp", "AAAA", `odd,"name".example.com`, "", `{"site":"tokyo"}`},
+		{"2024-01-01T00:00:00Z", testClient, "40000", testServer, "53", "udp", "AAAA", "www.example.com", "2001:db8::80", ""},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("read %d records, want %d: %v", len(records), len(want), records)
+	}
+	for i := range want {
+		if strings.Join(records[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("record %d = %q, want %q", i, records[i], want[i])
+		}
+	}
+}
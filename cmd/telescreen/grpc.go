@@ -0,0 +1,216 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/wide-vsix/telescreen/telescreenpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const subscriberQueueSize int = 1024
+
+type subscriber struct {
+	filter *telescreenpb.Filter
+	events chan *telescreenpb.DnsEvent
+}
+
+// grpcServer fans out captured logs to the subscribers. Publishing never
+// blocks the capture, a subscriber whose queue is full is dropped instead.
+type grpcServer struct {
+	telescreenpb.UnimplementedTelescreenServer
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+func (s *grpcServer) Subscribe(filter *telescreenpb.Filter, stream telescreenpb.Telescreen_SubscribeServer) error {
+	sub := &subscriber{
+		filter: filter,
+		events: make(chan *telescreenpb.DnsEvent, subscriberQueueSize),
+	}
+	s.mu.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.mu.Unlock()
+	defer s.unsubscribe(sub)
+
+	for {
+		select {
+		case event, ok := <-sub.events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "subscriber is too slow to keep up with the capture")
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func (s *grpcServer) unsubscribe(sub *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscribers[sub]; ok {
+		delete(s.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+func (s *grpcServer) publish(qr telescreenLog) {
+	event := newDnsEvent(qr)
+	if event == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.subscribers {
+		if !matchFilter(sub.filter, event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			delete(s.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+func matchFilter(filter *telescreenpb.Filter, event *telescreenpb.DnsEvent) bool {
+	if qtypes := filter.GetQueryTypes(); len(qtypes) > 0 {
+		matched := false
+		for _, qtype := range qtypes {
+			matched = matched || strings.EqualFold(qtype, event.QueryType)
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if suffixes := filter.GetDomainSuffixes(); len(suffixes) > 0 {
+		name := event.QueryString
+		if event.Sni != "" {
+			name = event.Sni
+		}
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		matched := false
+		for _, suffix := range suffixes {
+			suffix = strings.ToLower(strings.Trim(suffix, "."))
+			matched = matched || name == suffix || strings.HasSuffix(name, "."+suffix)
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newDnsEvent(qr telescreenLog) *telescreenpb.DnsEvent {
+	var c *telescreenLogCommon
+	var q *QueryLog
+	var log *ResponseLog
+	event := new(telescreenpb.DnsEvent)
+
+	switch l := qr.(type) {
+	case *DoTLog:
+		c = &l.telescreenLogCommon
+		event.Sni = l.SNI
+	case *QueryLog:
+		q = l
+	case *ResponseLog:
+		log = l
+		event.Response = true
+	case *CombinedLog:
+		log = &l.ResponseLog
+		event.Combined = true
+		event.LatencyUs = l.LatencyUs
+		event.Timeout = l.Timeout
+	default:
+		return nil
+	}
+
+	if log != nil {
+		q = &log.QueryLog
+		if log.AnsIP != nil {
+			event.AnswerIp = log.AnsIP.String()
+		}
+		event.Ipv6Ready = log.IPv6Ready
+		event.LikelyCached = log.LikelyCached
+		event.NewAnswer = log.NewAnswer
+		event.Mismatch = log.Mismatch
+		event.RefAnswer = log.RefAnswer
+		event.AnswerTypes = log.AnsTypes
+		event.AnswerSection = log.AnsSection
+		event.ServerId = log.ServerID
+		event.CnameHops = uint32(log.CNAMEHops)
+		for _, e := range log.ExtErrors {
+			event.ExtendedErrors = append(event.ExtendedErrors, &telescreenpb.ExtendedError{
+				InfoCode:  uint32(e.InfoCode),
+				ExtraText: e.ExtraText,
+			})
+		}
+	}
+
+	if q != nil {
+		c = &q.telescreenLogCommon
+	}
+
+	event.ReceivedAt = timestamppb.New(c.Timestamp)
+	event.SrcIp = c.SrcIP.String()
+	event.DstIp = c.DstIP.String()
+	event.SrcPort = uint32(c.SrcPort)
+	event.DstPort = uint32(c.DstPort)
+	event.TcpTransport = c.TransTCP
+	event.Transport = c.Transport
+	event.ClientIp = c.ClientIP.String()
+	event.ClientPort = uint32(c.ClientPort)
+	event.ServerIp = c.ServerIP.String()
+	event.ServerPort = uint32(c.ServerPort)
+	event.SourceHost = c.SourceHost
+	event.CaptureHost = c.CaptureHost
+	event.CaptureInterface = c.Interface
+	event.Fields = c.Fields
+	if q == nil {
+		return event
+	}
+
+	event.QueryString = q.QString
+	event.QueryType = q.QType
+	event.TransactionId = uint32(q.TransID)
+	event.Opcode = q.Opcode
+	if q.PTRAddr != nil {
+		event.PtrAddress = q.PTRAddr.String()
+	}
+	return event
+}
+
+func newGRPCExporter(addr string) (func(qr telescreenLog), func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	server := &grpcServer{subscribers: make(map[*subscriber]struct{})}
+	s := grpc.NewServer()
+	telescreenpb.RegisterTelescreenServer(s, server)
+	go s.Serve(listener)
+
+	exporter := func(qr telescreenLog) {
+		if qr != nil {
+			server.publish(qr)
+		}
+	}
+
+	closer := func() {
+		s.Stop()
+	}
+
+	return exporter, closer, nil
+}
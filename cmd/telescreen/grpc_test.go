@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wide-vsix/telescreen/telescreenpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestGRPC serves the streaming API in process, returning the server to
+// publish to and a client connected to it.
+func newTestGRPC(t *testing.T) (*grpcServer, telescreenpb.TelescreenClient) {
+	t.Helper()
+	listener := bufconn.Listen(1 << 16)
+	server := &grpcServer{subscribers: make(map[*subscriber]struct{})}
+	s := grpc.NewServer()
+	telescreenpb.RegisterTelescreenServer(s, server)
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	dial := func(ctx context.Context, addr string) (net.Conn, error) { return listener.Dial() }
+	conn, err := grpc.Dial("bufconn", grpc.WithContextDialer(dial), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return server, telescreenpb.NewTelescreenClient(conn)
+}
+
+// waitSubscribers waits until the server has as many subscribers.
+func waitSubscribers(t *testing.T, server *grpcServer, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		server.mu.Lock()
+		subscribed := len(server.subscribers)
+		server.mu.Unlock()
+		if subscribed == n {
+			return
+		}
+	}
+	t.Fatalf("%d subscribers never came", n)
+}
+
+func TestGRPCSubscribe(t *testing.T) {
+	server, client := newTestGRPC(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &telescreenpb.Filter{QueryTypes: []string{"AAAA"}, DomainSuffixes: []string{"example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribers(t, server, 1)
+
+	// Only the query matching both criteria is streamed
+	server.publish(&QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.com", QType: "A"})
+	server.publish(&QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.net", QType: "AAAA"})
+	server.publish(&QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.com", QType: "AAAA"})
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.QueryString != "www.example.com" || event.QueryType != "AAAA" || event.SrcIp != testClient || event.DstPort != 53 || event.Response {
+		t.Errorf("event = %v", event)
+	}
+
+	cancel()
+	waitSubscribers(t, server, 0)
+}
+
+func TestGRPCDropsSlowSubscriber(t *testing.T) {
+	server, client := newTestGRPC(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &telescreenpb.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribers(t, server, 1)
+
+	// The client reads nothing, so once the flow control window and the
+	// queue fill up the subscriber is dropped, without blocking publish
+	q := &QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.com", QType: "AAAA"}
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < 64*subscriberQueueSize; i++ {
+			server.publish(q)
+		}
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing blocked by a slow subscriber")
+	}
+	waitSubscribers(t, server, 0)
+
+	// What was queued is still delivered before the error
+	received := 0
+	for {
+		if _, err = stream.Recv(); err != nil {
+			break
+		}
+		received += 1
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("stream ended with %v, want ResourceExhausted", err)
+	}
+	if received == 0 || received >= 64*subscriberQueueSize {
+		t.Errorf("received %d events, want some but not all", received)
+	}
+}
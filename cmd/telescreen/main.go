@@ -1,14 +1,24 @@
 package main
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
+	"os/signal"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
-	"github.com/go-pg/pg/v10"
-	"github.com/go-pg/pg/v10/orm"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
@@ -17,66 +27,266 @@ import (
 )
 
 const (
-	filter      string        = "port 53" // Only capturing DNS packets, both queries and responses
+	filter      string        = "port 53 or ip6[6] = 44"                 // Only capturing DNS packets, both queries and responses, and IPv6 fragments
+	dotFilter   string        = "port 53 or ip6[6] = 44 or tcp port 853" // Also capturing DNS over TLS with --dot
 	snaplen     int32         = 1600
 	promiscuous bool          = true
-	timeout     time.Duration = pcap.BlockForever
+	timeout     time.Duration = 100 * time.Millisecond // Bounds how long closing the handle waits for a blocked read
+
+	// Transports a log was carried over
+	transportUDP string = "udp"
+	transportTCP string = "tcp"
+	transportDoT string = "dot"
+
+	maxTTLCacheEntries int = 65536 // Default of --max-memory-cache-entries
+	maxAnswersPerName  int = 8     // Answers remembered for each name with --track-answer-churn
+	exportQueueSize    int = 4096  // Logs waiting for each exporter, beyond which --overflow-policy applies
+
+	unixSocketTimeout time.Duration = 5 * time.Second // Bounds connecting and writing to --unix-socket
+
+	// Filters of the two handles with --split-capture, which together match
+	// the same packets as filter and dotFilter, each exactly once
+	queryFilter       string = "dst port 53 and not src port 53"
+	dotQueryFilter    string = "(dst port 53 and not src port 53) or tcp dst port 853"
+	responseFilter    string = "src port 53 or ip6[6] = 44"
+	dotResponseFilter string = "src port 53 or ip6[6] = 44 or tcp src port 853"
 )
 
 var (
 	VERSION       string = "0.0.0"
 	REVISION      string = "develop"
 	device        string // Where DNS packets are forwarded
-	dbAddr        string // Postgresql: IP address and port number pair
-	dbName        string // Postgresql: Database name
-	dbUser        string // Postgresql: Login username
-	dbPassFile    string // Postgresql: Login password file
+	readFile      string // pcap file to read instead of capturing live
+	sinceFlag     string // Offline: skip packets captured before this time
+	untilFlag     string // Offline: stop at packets captured after this time
+	captureHost   string // Host name recorded with logs, the hostname by default
+	showCapture   bool   // Prefix lines with the capture host and interface
+	since         time.Time
+	until         time.Time
+	dbDriver      string        // Database: postgres or mysql
+	dbAddr        string        // Database: IP address and port number pair
+	dbName        string        // Database: Database name
+	dbUser        string        // Database: Login username
+	dbPassFile    string        // Database: Login password file
+	logFile       string        // Where to write logs in addition to the standard output
+	logFormat     string        // Encoding of the log file: text, json or csv
+	timeFormat    string        // Timestamps in text: rfc3339, rfc3339nano, unix, unixnano or a Go layout
+	protobufFile  string        // Where to write length-delimited DnsEvent messages
+	unixSocket    string        // Unix domain socket to write JSON lines to
+	parquetFile   string        // Where to write Parquet files, numbered as they roll
+	parquetRows   int           // Roll to the next Parquet file after this many rows, 0 means no limit
+	parquetBytes  int64         // Roll to the next Parquet file after this many bytes, 0 means no limit
+	bufferSize    int           // Kernel buffer size in bytes, 0 means the libpcap default
+	rpcapUser     string        // Login username of rpcapd, null authentication without it
+	rpcapPassword string        // Login password of rpcapd
+	splitFlag     bool          // Capture queries and responses by two handles
+	querySnaplen  int           // Snaplen of the query handle with --split-capture
+	respSnaplen   int           // Snaplen of the response handle with --split-capture
+	flushInterval time.Duration // Buffer the standard output and flush it this often, 0 means unbuffered
+	maxRate       float64       // Logs exported per second at most, 0 means no limit
+	compareAddr   string        // Reference resolver to export only the responses disagreeing with
+	compareRate   float64       // Lookups to the reference resolver per second at most
+	grpcAddr      string        // Where to serve the gRPC streaming API
+	replayAddr    string        // Where to accept pcap streams instead of capturing
+	replayConns   int           // pcap streams handled at once
+	metricsAddr   string        // Where to serve the Prometheus metrics
+	cpuProfile    string        // Where to write the CPU profile
+	memProfile    string        // Where to write the heap profile on exit
+	benchFlag     bool
+	reconnectFlag bool
+	reconnectMax  int           // Give up reconnecting after this many attempts, 0 means never
+	idleTimeout   time.Duration // Reopen the interface after this long without packets, 0 means never
 	quietFlag     bool
+	explodeFlag   bool   // Print a response once for each address it answers
+	answerSelect  string // Answer logged of several: first, last, or all for first and --explode-answers
 	containerFlag bool
 	helpFlag      bool
-	sniffFlag     bool
+	listFlag      bool
+	sniffFlag     bool // Same as --capture-responses --response-types AAAA --drop-empty-response
+	responsesOnly bool
+	captureFlag   bool     // Export responses
+	responseTypes []string // Export only responses to these query types, implies --capture-responses
+	dropEmpty     bool     // Do not export responses without an address
+	dotFlag       bool
+	cachedFlag    bool
+	cacheEntries  int // Entries each in-memory cache holds at most
+	churnFlag     bool
+	churnWindow   time.Duration // Answers not seen for this long are forgotten
+	firstInterval time.Duration // Pass a query of a client for a name once in this long, 0 means every query
+	newOnly       bool          // Pass a query only for a name never seen before
+	seenDB        string        // Where the names seen are kept across restarts with --only-new-domains
+	seenTTL       time.Duration // Names not seen for this long are new again, 0 means never
+	combinedFlag  bool          // Export a query and its response as one log
+	combineWait   time.Duration // Queries are exported as timed out after this long without a response
+	followCNAME   bool          // Log the address a CNAME chain ends at instead of the first CNAME
+	traceFlag     bool
+	answerCIDRs   []string     // Only responses with an answer in these networks are exported
+	answerNets    []*net.IPNet // Parsed from answerCIDRs
+	triggerRcode  string       // Dump the held logs on a response with this code
+	rcodeNames    []string     // Only responses with these codes are exported
+	triggerDomain string       // Dump the held logs on a query for a name under this domain
+	ringSize      int          // Logs held until a trigger at most
+	direction     string       // auto, or query or response to treat every packet as such
+	fqdnStyle     string       // strip or keep the trailing dot of names
+	anonymizeFlag bool
+	anonymizeKey  string        // HMAC key for the host portion of client addresses, zeroed if empty
+	minLabels     int           // Drop queries for names with fewer labels, 0 means no limit
+	maxLabels     int           // Drop queries for names with more labels, 0 means no limit
+	triggerWindow time.Duration // Logs are held for this long before a trigger and passed after it
 	versionFlag   bool
 	err           error
-	errCounter    uint16
+	ttlCache      *maxTTLCache    // Highest TTLs seen, only with --detect-cached
+	answerChurn   *answerHistory  // Answers seen recently, only with --track-answer-churn
+	ring          *triggerRing    // Logs held until a trigger, only with --trigger-*
+	latencies     *latencyMetrics // Resolution latencies, only with --metrics-addr
+	limiter       *rateLimiter    // Shared by the exporters, only with --max-rate
+
+	firstSeen *firstSeenFilter // Queries passed recently, only with --first-seen-interval
+	newNames  *seenDomains     // Names ever queried, only with --only-new-domains
+	combiner  *queryCombiner   // Queries waiting for their responses, only with --combined
+
+	includeRegexes []string         // Only names matching any of these are exported
+	excludeRegexes []string         // Names matching any of these are dropped, even if included
+	includeNames   []*regexp.Regexp // Compiled from includeRegexes
+	excludeNames   []*regexp.Regexp // Compiled from excludeRegexes
+
+	rcodeFilter []layers.DNSResponseCode // Parsed from rcodeNames
+
+	overflowPolicy string // What an exporter queue drops when full: block, drop-newest or drop-oldest
+
+	legacyResponseTypes = []string{"AAAA"} // Responses -A used to store
+	_, nat64Prefix, _   = net.ParseCIDR("64:ff9b::/96")
+
+	errIdle = errors.New("no packets for --idle-timeout")
+
+	shutdown     = make(chan struct{}) // Closed by stopRun
+	shutdownOnce sync.Once
 )
 
+// stopRun ends the capture as SIGTERM does, e.g., when an exporter gives up.
+func stopRun() {
+	shutdownOnce.Do(func() { close(shutdown) })
+}
+
 type telescreenLog interface {
 	String() string
 	Colorize() string
 }
 
 type telescreenLogCommon struct {
-	Timestamp time.Time `pg:"received_at"`
-	SrcIP     net.IP    `pg:"src_ip"`
-	DstIP     net.IP    `pg:"dst_ip"`
-	SrcPort   uint16    `pg:"src_port"`
-	DstPort   uint16    `pg:"dst_port"`
-	TransTCP  bool      `pg:"tcp_transport,notnull,use_zero"`
+	Timestamp time.Time `pg:"received_at" json:"received_at"`
+	SrcIP     net.IP    `pg:"src_ip" json:"src_ip"`
+	DstIP     net.IP    `pg:"dst_ip" json:"dst_ip"`
+	SrcPort   uint16    `pg:"src_port" json:"src_port"`
+	DstPort   uint16    `pg:"dst_port" json:"dst_port"`
+	TransTCP  bool      `pg:"tcp_transport,notnull,use_zero" json:"tcp_transport"` // Kept for compatibility, see Transport
+	Transport string    `pg:"transport" json:"transport"`                          // udp, tcp or dot
+
+	// Endpoints by role, which unlike src/dst stay the same for a query and
+	// its response
+	ClientIP   net.IP `pg:"client_ip" json:"client_ip"`
+	ClientPort uint16 `pg:"client_port" json:"client_port"`
+	ServerIP   net.IP `pg:"server_ip" json:"server_ip"`
+	ServerPort uint16 `pg:"server_port" json:"server_port"`
+
+	SourceHost string `pg:"source_host" json:"source_host"` // Agent that streamed the packet with --listen-replay, empty if captured here
+
+	// Where the packet was captured, to tell sensors apart in one database
+	CaptureHost string `pg:"capture_host" json:"capture_host"`
+	Interface   string `pg:"capture_interface" json:"capture_interface"` // Of the capture, empty with --read and --listen-replay
+
+	Fields map[string]string `pg:"fields" json:"fields,omitempty"` // Added by enrichers
 }
 
 type QueryLog struct {
 	telescreenLogCommon
-	QString   string `pg:"query_string"`
-	QType     string `pg:"query_type"`
-	hasAnswer bool   `pg:"-"`
+	QString    string `pg:"query_string" json:"query_string"`
+	QType      string `pg:"query_type" json:"query_type"`
+	PTRAddr    net.IP `pg:"ptr_address" json:"ptr_address"` // Decoded from the name of a reverse lookup
+	TransID    uint16 `pg:"transaction_id,use_zero" json:"transaction_id"`
+	Opcode     string `pg:"opcode" json:"opcode"` // QUERY, NOTIFY, UPDATE and so on
+	hasAnswer  bool   `pg:"-"`
+	isResponse bool   `pg:"-"` // QR bit of the DNS header
 }
 
 type ResponseLog struct {
 	QueryLog
-	AnsIP     net.IP `pg:"answer_ip"`
-	IPv6Ready bool   `pg:"ipv6_ready,notnull,use_zero"`
+	AnsIP        net.IP `pg:"answer_ip" json:"answer_ip"`
+	IPv6Ready    bool   `pg:"ipv6_ready,notnull,use_zero" json:"ipv6_ready"`
+	LikelyCached bool   `pg:"likely_cached,notnull,use_zero" json:"likely_cached"`
+	AnsTypes     string `pg:"answer_types" json:"answer_types"`
+	AnsSection   string `pg:"answer_section" json:"answer_section"`  // ANSWER, AUTHORITY or ADDITIONAL
+	ServerID     string `pg:"server_id" json:"server_id"`            // EDNS NSID of the server answered, if any
+	CNAMEHops    uint16 `pg:"cname_hops,use_zero" json:"cname_hops"` // CNAMEs followed to the answer with --follow-cname
+	NewAnswer    bool   `pg:"new_answer,notnull,use_zero" json:"new_answer"`
+	Mismatch     bool   `pg:"mismatch,notnull,use_zero" json:"mismatch"` // Answer differs from that of --compare-resolver
+	RefAnswer    string `pg:"ref_answer" json:"ref_answer"`              // Addresses --compare-resolver answered, comma separated
+
+	ExtErrors []extendedError `pg:"extended_errors" json:"extended_errors"` // Extended DNS Errors of RFC 8914, if any
+
+	answerIPs []net.IP // Every address in the answer section, for --explode-answers
 }
 
 func (q *QueryLog) String() string {
-	ts := q.Timestamp.Format(time.RFC3339)
+	ts := formatTime(q.Timestamp)
 	src := fmt.Sprintf("%s.%d", q.SrcIP.String(), q.SrcPort)
 	dst := fmt.Sprintf("%s.%d", q.DstIP.String(), q.DstPort)
 	qtype := fmt.Sprintf("%s?", q.QType)
-	trans := "UDP"
-	if q.TransTCP {
-		trans = "TCP"
+	return fmt.Sprintf("%s | %s%-43s > %-25s %s %-5d %-8s %s%s", ts, q.capturePoint(), src, dst, q.transportName(), q.TransID, qtype, q.displayName(), q.fieldsString())
+}
+
+// formatTime renders a timestamp in text as --time-format tells. Nanoseconds
+// are not trimmed unlike time.RFC3339Nano, so that lines stay aligned.
+func formatTime(t time.Time) string {
+	switch timeFormat {
+	case "", "rfc3339":
+		return t.Format(time.RFC3339)
+	case "rfc3339nano":
+		return t.Format("2006-01-02T15:04:05.000000000Z07:00")
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "unixnano":
+		return strconv.FormatInt(t.UnixNano(), 10)
+	default:
+		return t.Format(timeFormat)
 	}
-	return fmt.Sprintf("%s | %-43s > %-25s %s %-8s %s", ts, src, dst, trans, qtype, q.QString)
+}
+
+// capturePoint shows where the packet was captured as host%interface, as a
+// column of its own, with --show-capture-host only.
+func (c *telescreenLogCommon) capturePoint() string {
+	if !showCapture {
+		return ""
+	}
+	point := c.CaptureHost
+	if c.Interface != "" {
+		point += "%" + c.Interface
+	}
+	return point + " | "
+}
+
+// transportName shows the transport as it is usually written.
+func (c *telescreenLogCommon) transportName() string {
+	switch c.Transport {
+	case transportDoT:
+		return "DoT"
+	default:
+		return strings.ToUpper(c.Transport)
+	}
+}
+
+// displayName shows the address being looked up along with a reverse name,
+// and the opcode of a message other than a standard query, e.g., the zone of a
+// NOTIFY as "NOTIFY example.com.".
+func (q *QueryLog) displayName() string {
+	name := q.QString
+	if q.PTRAddr != nil {
+		name = fmt.Sprintf("%s (%s)", q.QString, q.PTRAddr)
+	}
+	if q.Opcode != "" && q.Opcode != "QUERY" {
+		name = strings.TrimSpace(q.Opcode + " " + name)
+	}
+	return name
 }
 
 func (q *QueryLog) Colorize() string {
@@ -91,15 +301,48 @@ func (q *QueryLog) Colorize() string {
 }
 
 func (r *ResponseLog) String() string {
-	ts := r.Timestamp.Format(time.RFC3339)
+	ts := formatTime(r.Timestamp)
 	src := fmt.Sprintf("%s.%d", r.SrcIP.String(), r.SrcPort)
 	dst := fmt.Sprintf("%s.%d", r.DstIP.String(), r.DstPort)
 	qtype := fmt.Sprintf("%s?", r.QType)
-	trans := "UDP"
-	if r.TransTCP {
-		trans = "TCP"
+	return fmt.Sprintf("%s | %s%-43s < %-25s %s %-5d %-8s %s [%s] (%s)%s", ts, r.capturePoint(), dst, src, r.transportName(), r.TransID, qtype, r.displayName(), r.AnsTypes, r.answerDetail(), r.fieldsString())
+}
+
+// answerDetail shows the answer along with what is known about it.
+func (r *ResponseLog) answerDetail() string {
+	answer := r.AnsIP.String()
+	if r.AnsIP == nil {
+		answer = "no answer"
+	}
+	if r.AnsSection != "ANSWER" && r.AnsSection != "" {
+		answer += ", in " + r.AnsSection
+	}
+	if r.LikelyCached {
+		answer += ", likely cached"
+	}
+	if r.NewAnswer {
+		answer += ", new answer"
 	}
-	return fmt.Sprintf("%s | %-43s < %-25s %s %-8s %s (%s)", ts, dst, src, trans, qtype, r.QString, r.AnsIP)
+	if r.Mismatch {
+		ref := r.RefAnswer
+		if ref == "" {
+			ref = "no answer"
+		}
+		answer += ", reference " + ref
+	}
+	if r.CNAMEHops > 0 {
+		answer += fmt.Sprintf(", via %d CNAME", r.CNAMEHops)
+		if r.CNAMEHops > 1 {
+			answer += "s"
+		}
+	}
+	if r.ServerID != "" {
+		answer += ", NSID " + r.ServerID
+	}
+	for _, e := range r.ExtErrors {
+		answer += ", " + e.String()
+	}
+	return answer
 }
 
 func (r *ResponseLog) Colorize() string {
@@ -111,10 +354,15 @@ func (r *ResponseLog) Colorize() string {
 
 func newTelescreenLogCommon(packet gopacket.Packet) *telescreenLogCommon {
 	c := new(telescreenLogCommon)
-	c.Timestamp = time.Now()
+	c.Timestamp = packet.Metadata().Timestamp
+	c.CaptureHost = captureHost
+	if c.Timestamp.IsZero() {
+		c.Timestamp = time.Now()
+	}
 
 	if err := packet.ErrorLayer(); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to decode some part of the packet: %v\n", err)
+		trace(packet, dropDecodeError)
 		return nil
 	}
 
@@ -123,152 +371,1056 @@ func newTelescreenLogCommon(packet gopacket.Packet) *telescreenLogCommon {
 		c.SrcIP = ip6.SrcIP
 		c.DstIP = ip6.DstIP
 	} else {
+		trace(packet, dropNoIPv6)
 		return nil
 	}
 
-	if udpLayer := packet.Layer(layers.LayerTypeUDP); udpLayer != nil {
-		udp, _ := udpLayer.(*layers.UDP)
-		c.SrcPort = uint16(udp.SrcPort)
-		c.DstPort = uint16(udp.DstPort)
-	}
-
-	if tcpLayer := packet.Layer(layers.LayerTypeTCP); tcpLayer != nil {
-		tcp, _ := tcpLayer.(*layers.TCP)
-		c.SrcPort = uint16(tcp.SrcPort)
-		c.DstPort = uint16(tcp.DstPort)
+	switch transport := transportLayer(packet).(type) {
+	case *layers.UDP:
+		c.SrcPort = uint16(transport.SrcPort)
+		c.DstPort = uint16(transport.DstPort)
+		c.Transport = transportUDP
+	case *layers.TCP:
+		c.SrcPort = uint16(transport.SrcPort)
+		c.DstPort = uint16(transport.DstPort)
 		c.TransTCP = true
+		c.Transport = transportTCP
+	default:
+		trace(packet, dropNoTransport)
+		return nil
 	}
 
 	return c
 }
 
+// setEndpoints identifies the client and the server by the direction of the
+// packet, i.e., a response is sent from the server to the client.
+func (c *telescreenLogCommon) setEndpoints(response bool) {
+	if response {
+		c.ClientIP, c.ClientPort = c.DstIP, c.DstPort
+		c.ServerIP, c.ServerPort = c.SrcIP, c.SrcPort
+	} else {
+		c.ClientIP, c.ClientPort = c.SrcIP, c.SrcPort
+		c.ServerIP, c.ServerPort = c.DstIP, c.DstPort
+	}
+}
+
+// transportLayer walks past the IPv6 extension headers (Hop-by-Hop, Routing and
+// Destination Options) and returns the layer that follows them. A fragment
+// header is returned as it is since its payload cannot be decoded any further.
+func transportLayer(packet gopacket.Packet) gopacket.Layer {
+	found := false
+	for _, layer := range packet.Layers() {
+		switch layer.LayerType() {
+		case layers.LayerTypeIPv6, layers.LayerTypeIPv6HopByHop, layers.LayerTypeIPv6Routing, layers.LayerTypeIPv6Destination:
+			found = true
+		default:
+			if found {
+				return layer
+			}
+		}
+	}
+	return nil
+}
+
 func newQueryLog(packet gopacket.Packet, c *telescreenLogCommon) *QueryLog {
 	q := new(QueryLog)
 	q.telescreenLogCommon = *c
 
 	if dnsLayer := packet.Layer(layers.LayerTypeDNS); dnsLayer != nil {
 		dns, _ := dnsLayer.(*layers.DNS)
+		q.Opcode = opcodeName(dns.OpCode)
+		q.isResponse = dns.QR
+		q.TransID = dns.ID
+		q.setEndpoints(q.isResponse)
+
+		// The question section of an UPDATE or NOTIFY names the zone, and the
+		// records following it are not answers
 		if len(dns.Questions) > 0 {
 			question := dns.Questions[0]
-			q.QString = string(question.Name)
+			q.QString = canonicalName(string(question.Name))
 			q.QType = question.Type.String()
-			q.hasAnswer = len(dns.Answers) > 0
+			if question.Type == layers.DNSTypePTR {
+				q.PTRAddr = decodeReverseName(q.QString)
+			}
+			q.hasAnswer = dns.OpCode == layers.DNSOpCodeQuery && len(dns.Answers) > 0
+			return q
+		}
+		// Neither is meaningful without the zone, but still worth recording
+		if dns.OpCode == layers.DNSOpCodeNotify || dns.OpCode == layers.DNSOpCodeUpdate {
 			return q
 		}
+		trace(packet, dropNoQuestion)
+	} else {
+		trace(packet, dropNoDNS)
+	}
+
+	return nil
+}
+
+// canonicalName strips or keeps the trailing dot of the name as --fqdn-style
+// tells, so that a name is always logged alike whether it came with the dot or
+// not. The root is "." when kept.
+func canonicalName(name string) string {
+	name = strings.TrimSuffix(name, ".")
+	if fqdnStyle == "keep" {
+		return name + "."
+	}
+	return name
+}
+
+// opcodeName names the opcode as dig shows it, or by number if unknown.
+func opcodeName(opcode layers.DNSOpCode) string {
+	switch opcode {
+	case layers.DNSOpCodeQuery:
+		return "QUERY"
+	case layers.DNSOpCodeIQuery:
+		return "IQUERY"
+	case layers.DNSOpCodeStatus:
+		return "STATUS"
+	case layers.DNSOpCodeNotify:
+		return "NOTIFY"
+	case layers.DNSOpCodeUpdate:
+		return "UPDATE"
+	default:
+		return strconv.Itoa(int(opcode))
+	}
+}
+
+// trace counts why the packet produced no log, one of the drop* reasons, and
+// tells it with --trace.
+func trace(packet gopacket.Packet, reason string) {
+	countDrop(reason)
+	if !traceFlag {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Dropped packet captured at %s (%d bytes): %s\n", packet.Metadata().Timestamp.Format(time.RFC3339Nano), len(packet.Data()), dropReasons[reason])
+}
+
+// decodeReverseName reconstructs the address from a reverse lookup name such as
+// 1.0.0.127.in-addr.arpa or the nibble format under ip6.arpa. It returns nil
+// unless the name represents a complete address.
+func decodeReverseName(name string) net.IP {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	switch {
+	case strings.HasSuffix(name, ".in-addr.arpa"):
+		labels := strings.Split(strings.TrimSuffix(name, ".in-addr.arpa"), ".")
+		if len(labels) != net.IPv4len {
+			return nil
+		}
+		ip := make(net.IP, net.IPv4len)
+		for i, label := range labels {
+			n, err := strconv.ParseUint(label, 10, 8)
+			if err != nil {
+				return nil
+			}
+			ip[net.IPv4len-1-i] = byte(n)
+		}
+		return ip
+
+	case strings.HasSuffix(name, ".ip6.arpa"):
+		labels := strings.Split(strings.TrimSuffix(name, ".ip6.arpa"), ".")
+		if len(labels) != net.IPv6len*2 {
+			return nil
+		}
+		ip := make(net.IP, net.IPv6len)
+		for i, label := range labels {
+			if len(label) != 1 {
+				return nil
+			}
+			n, err := strconv.ParseUint(label, 16, 8)
+			if err != nil {
+				return nil
+			}
+			// The first label is the lowest nibble of the address
+			nibble := net.IPv6len*2 - 1 - i
+			if nibble%2 == 0 {
+				n <<= 4
+			}
+			ip[nibble/2] |= byte(n)
+		}
+		return ip
 	}
 
 	return nil
 }
 
+// newResponseLog extends the query fields parsed from the response itself,
+// whose question section repeats that of the query. A response is thus logged
+// in full even if its query was never captured, with or without answers.
 func newResponseLog(packet gopacket.Packet, q *QueryLog) *ResponseLog {
 	r := new(ResponseLog)
 	r.QueryLog = *q
-	_, nat64_prefix, _ := net.ParseCIDR("64:ff9b::/96")
+	r.hasAnswer = false
 
-	if dnsLayer := packet.Layer(layers.LayerTypeDNS); dnsLayer != nil {
-		dns, _ := dnsLayer.(*layers.DNS)
-		if len(dns.Answers) > 0 {
-			answer := dns.Answers[0]
-			r.AnsIP = answer.IP
-			r.IPv6Ready = !nat64_prefix.Contains(r.AnsIP)
-			r.hasAnswer = answer.IP != nil
-			return r
+	dnsLayer := packet.Layer(layers.LayerTypeDNS)
+	if dnsLayer == nil {
+		return nil
+	}
+	dns, _ := dnsLayer.(*layers.DNS)
+	r.ServerID = serverID(dns)
+	r.ExtErrors = extendedErrors(dns)
+	if dns.OpCode != layers.DNSOpCodeQuery {
+		return r
+	}
+	r.AnsTypes = summarizeTypes(dns.Answers)
+	for _, record := range dns.Answers {
+		if record.IP != nil {
+			r.answerIPs = append(r.answerIPs, record.IP)
 		}
 	}
 
-	return nil
+	answer, section := primaryAnswer(dns)
+	if followCNAME {
+		if final, hops := followCNAMEs(dns, r.QString); final != nil && hops > 0 {
+			answer, section = final, "ANSWER"
+			r.CNAMEHops = uint16(hops)
+		}
+	}
+
+	// A response without any answer, e.g., NXDOMAIN, is still logged
+	if answer != nil {
+		r.AnsIP = answer.IP
+		r.IPv6Ready = !nat64Prefix.Contains(r.AnsIP)
+		r.hasAnswer = answer.IP != nil
+		r.AnsSection = section
+		if ttlCache != nil {
+			r.LikelyCached = ttlCache.observe(r.QString, r.QType, answer.TTL)
+		}
+		if answerChurn != nil && r.AnsIP != nil {
+			r.NewAnswer = answerChurn.observe(r.QString, r.QType, r.AnsIP, r.Timestamp)
+		}
+	}
+	return r
 }
 
-func stdExporter(qr telescreenLog) {
-	if qr != nil {
-		fmt.Println(qr.Colorize())
+// serverID returns the NSID option of the OPT record, which identifies the
+// server behind an anycast address. It is shown as it is if printable,
+// otherwise in hex.
+func serverID(dns *layers.DNS) string {
+	for _, record := range dns.Additionals {
+		if record.Type != layers.DNSTypeOPT {
+			continue
+		}
+		for _, option := range record.OPT {
+			if option.Code != layers.DNSOptionCodeNSID {
+				continue
+			}
+			for _, b := range option.Data {
+				if b < 0x20 || b > 0x7e {
+					return hex.EncodeToString(option.Data)
+				}
+			}
+			return string(option.Data)
+		}
 	}
+	return ""
 }
 
-func newDBExporter(options *pg.Options) (func(qr telescreenLog), func()) {
-	db := pg.Connect(options)
-	schemas := []interface{}{
-		(*QueryLog)(nil),
-		(*ResponseLog)(nil),
+// primaryAnswer returns the record to be logged and the section it came from.
+// The first answer wins, or the last one with --answer-select last. Without
+// one, e.g., a referral, an address in the authority or additional section
+// such as glue is picked instead.
+func primaryAnswer(dns *layers.DNS) (*layers.DNSResourceRecord, string) {
+	if len(dns.Answers) > 0 && answerSelect == "last" {
+		return &dns.Answers[len(dns.Answers)-1], "ANSWER"
+	}
+	if len(dns.Answers) > 0 {
+		return &dns.Answers[0], "ANSWER"
 	}
-	for _, schema := range schemas {
-		db.Model(schema).CreateTable(&orm.CreateTableOptions{
-			IfNotExists: true,
-		})
+	for i := range dns.Authorities {
+		if dns.Authorities[i].IP != nil {
+			return &dns.Authorities[i], "AUTHORITY"
+		}
 	}
+	for i := range dns.Additionals {
+		if dns.Additionals[i].IP != nil {
+			return &dns.Additionals[i], "ADDITIONAL"
+		}
+	}
+	return nil, ""
+}
 
-	exporter := func(qr telescreenLog) {
-		if _, err = db.Model(qr).Insert(); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to issue INSERT: %v\n", err)
-			errCounter += 1
-			if errCounter > 5 {
-				fmt.Fprintf(os.Stderr, "Exit with DB connection problem\n")
-				os.Exit(1)
+// followCNAMEs follows the CNAMEs from the name through the answer section to
+// the A or AAAA record the chain ends at, and returns it with the number of
+// CNAMEs followed. It returns nil if the chain ends without an address, or if
+// it loops.
+func followCNAMEs(dns *layers.DNS, name string) (*layers.DNSResourceRecord, int) {
+	for hops := 0; hops <= len(dns.Answers); hops++ {
+		var next *layers.DNSResourceRecord
+		for i := range dns.Answers {
+			record := &dns.Answers[i]
+			if !strings.EqualFold(strings.TrimSuffix(string(record.Name), "."), strings.TrimSuffix(name, ".")) {
+				continue
 			}
-			return
+			switch record.Type {
+			case layers.DNSTypeA, layers.DNSTypeAAAA:
+				return record, hops
+			case layers.DNSTypeCNAME:
+				next = record
+			}
+		}
+		if next == nil {
+			return nil, hops
 		}
-		errCounter = 0
+		name = string(next.CNAME)
 	}
+	return nil, 0
+}
 
-	closer := func() {
-		fmt.Println("Closing database connection...")
-		db.Close()
+// summarizeTypes counts the records per type in the order they first appear,
+// e.g., "1xCNAME, 2xA".
+func summarizeTypes(records []layers.DNSResourceRecord) string {
+	types := []layers.DNSType{}
+	counts := map[layers.DNSType]int{}
+	for _, record := range records {
+		if counts[record.Type] == 0 {
+			types = append(types, record.Type)
+		}
+		counts[record.Type] += 1
 	}
 
-	return exporter, closer
+	summary := make([]string, len(types))
+	for i, t := range types {
+		summary[i] = fmt.Sprintf("%dx%s", counts[t], t)
+	}
+	return strings.Join(summary, ", ")
 }
 
-func telescreen(exporters []func(telescreenLog)) {
-	handle, err := pcap.OpenLive(device, snaplen, promiscuous, timeout)
+// openLive opens the capture device through an inactive handle, which unlike
+// pcap.OpenLive allows setting the kernel buffer size before activation.
+func openLive(snaplen int) (*pcap.Handle, error) {
+	inactive, err := pcap.NewInactiveHandle(device)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to start capturing: %v\n", err)
-		return
+		return nil, err
 	}
-	defer handle.Close()
+	defer inactive.CleanUp()
 
-	if err = handle.SetBPFFilter(filter); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to set BPF filter: %v\n", err)
+	if err = inactive.SetSnapLen(snaplen); err != nil {
+		return nil, err
+	}
+	if err = inactive.SetPromisc(promiscuous); err != nil {
+		return nil, err
+	}
+	if err = inactive.SetTimeout(timeout); err != nil {
+		return nil, err
+	}
+	if bufferSize > 0 {
+		if err = inactive.SetBufferSize(bufferSize); err != nil {
+			return nil, err
+		}
 	}
 
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-	for packet := range packetSource.Packets() {
-		c := newTelescreenLogCommon(packet)
-		if c == nil {
-			continue
+	handle, err := inactive.Activate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Newer libpcap gives the "any" device the SLL2 header, which gopacket
+	// cannot decode, so ask for the original one. Neither carries the MAC
+	// addresses of both ends, which telescreen never records anyway.
+	if device == "any" {
+		if err = handle.SetLinkType(layers.LinkTypeLinuxSLL); err != nil {
+			handle.Close()
+			return nil, err
 		}
-		q := newQueryLog(packet, c)
-		if q == nil {
-			continue
+	}
+	return handle, nil
+}
+
+// openCapture opens the handles to capture from. There is one unless
+// --split-capture is given, where queries are captured with a small snaplen
+// and responses, which may be large with DNSSEC, with a large one.
+func openCapture() ([]captureHandle, error) {
+	if !splitFlag {
+		f := filter
+		if dotFlag {
+			f = dotFilter
+		}
+		handle, err := openHandle(int(snaplen), f)
+		if err != nil {
+			return nil, err
+		}
+		return []captureHandle{handle}, nil
+	}
+
+	qf, rf := queryFilter, responseFilter
+	if dotFlag {
+		qf, rf = dotQueryFilter, dotResponseFilter
+	}
+	queries, err := openHandle(querySnaplen, qf)
+	if err != nil {
+		return nil, err
+	}
+	responses, err := openHandle(respSnaplen, rf)
+	if err != nil {
+		queries.Close()
+		return nil, err
+	}
+	return []captureHandle{queries, responses}, nil
+}
+
+// openHandle opens the capture device, or the pcap file with --read, and
+// applies the BPF filter. A remote interface is given the filter as it starts
+// capturing.
+func openHandle(snaplen int, f string) (captureHandle, error) {
+	if readFile == "" && isRemoteDevice(device) {
+		handle, err := openRemote(snaplen, f)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to start capturing: %w", err)
+		}
+		return handle, nil
+	}
+
+	var handle *pcap.Handle
+	var err error
+	if readFile != "" {
+		handle, err = pcap.OpenOffline(readFile)
+	} else {
+		handle, err = openLive(snaplen)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to start capturing: %w", err)
+	}
+
+	if err = handle.SetBPFFilter(f); err != nil {
+		handle.Close()
+		return nil, fmt.Errorf("Failed to set BPF filter: %w", err)
+	}
+
+	return handle, nil
+}
+
+// captureHandle is what the capture reads packets from, i.e., a pcap handle.
+type captureHandle interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+	Close()
+}
+
+func closeHandles(handles []captureHandle) {
+	for _, handle := range handles {
+		handle.Close()
+	}
+}
+
+func telescreen(exporters []func(telescreenLog)) error {
+	// A pcap file may have been captured anywhere, so no interface is told
+	iface := device
+	if readFile != "" {
+		iface = ""
+	}
+	return captureFrom(iface, openCapture, exporters)
+}
+
+// captureFrom captures from the handles opened by open, and with --reconnect
+// opens them again whenever the capture ends unexpectedly. The logs are tagged
+// with iface as the interface captured on.
+func captureFrom(iface string, open func() ([]captureHandle, error), exporters []func(telescreenLog)) error {
+	handles, err := open()
+	if err != nil {
+		return err
+	}
+
+	// Closing the handle on a signal, or on stopRun, ends the capture cleanly,
+	// so that the exporters are closed on the way out of main.
+	var mu sync.Mutex
+	stopping := false
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	stop, done := shutdown, make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-sigs:
+		case <-stop:
+		case <-done:
+			return
 		}
-		r := newResponseLog(packet, q)
+		mu.Lock()
+		defer mu.Unlock()
+		stopping = true
+		closeHandles(handles)
+	}()
 
-		is_valid_query := c.DstPort == 53 && q != nil
-		is_valid_response := c.SrcPort == 53 && r != nil
-		has_aaaa_answer := is_valid_response && r.QType == "AAAA" && r.hasAnswer
+	retries := 0
+	for {
+		captured, err := capture(iface, handles, exporters)
+		closeHandles(handles)
 
-		var log telescreenLog = q
+		mu.Lock()
+		stopped := stopping
+		mu.Unlock()
 		switch {
-		case !is_valid_query && !has_aaaa_answer:
+		case stopped:
+			return nil
+		case errors.Is(err, errIdle):
+			// Reopened with or without --reconnect, as the capture looked
+			// healthy but was not
+			retries = 0
+		// A pcap file is read once, so it is never reopened
+		case readFile != "" && err != nil:
+			return fmt.Errorf("Reading %s ended: %w", readFile, err)
+		case !reconnectFlag && err != nil:
+			return fmt.Errorf("Capture on %s ended: %w", device, err)
+		case !reconnectFlag || readFile != "":
+			return nil
+		case captured:
+			retries = 0
+		}
+
+		for {
+			retries += 1
+			if reconnectMax > 0 && retries > reconnectMax {
+				return fmt.Errorf("Gave up reconnecting to %s after %d attempts", device, reconnectMax)
+			}
+			backoff := reconnectBackoff(retries)
+			fmt.Fprintf(os.Stderr, "Capture on %s ended unexpectedly, reconnecting in %v (attempt %d)\n", device, backoff, retries)
+			time.Sleep(backoff)
+
+			h, err := open()
+			mu.Lock()
+			if stopping {
+				mu.Unlock()
+				closeHandles(h)
+				return nil
+			}
+			if err == nil {
+				handles = h
+				mu.Unlock()
+				break
+			}
+			mu.Unlock()
+			// Privileges dropped meanwhile never come back by waiting
+			if isPermissionError(err) {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+}
+
+// reconnectBackoff doubles the wait from a second up to a minute.
+func reconnectBackoff(retries int) time.Duration {
+	backoff := time.Minute
+	if retries <= 6 {
+		backoff = time.Second << (retries - 1)
+	}
+	return backoff
+}
+
+// capture reads packets from the handles until they reach the end or fail,
+// e.g., the device went down. Unlike gopacket.PacketSource, which keeps
+// retrying on such errors, it reports the error so that the caller can decide
+// whether to reconnect. It also reports whether any packet was captured.
+// Packets from all the handles are merged into a single pipeline in order of
+// time, as a response may otherwise be read before its query. With
+// --idle-timeout on a live interface, a watchdog ends the capture with errIdle
+// once no packet arrives for the duration, e.g., the driver stopped
+// delivering them silently. The logs are tagged with iface.
+func capture(iface string, handles []captureHandle, exporters []func(telescreenLog)) (bool, error) {
+	var mu sync.Mutex
+	captured := false
+	var err error
+	last := time.Now().UnixNano() // Of the last packet, accessed atomically
+
+	done := make(chan struct{})
+	defer close(done)
+	if idle := idleTimeout; idle > 0 && readFile == "" {
+		go func() {
+			// Checked a few times within the timeout, but a ticker needs a
+			// positive interval
+			interval := idle / 4
+			if interval <= 0 {
+				interval = idle
+			}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+				case <-done:
+					return
+				}
+				if time.Since(time.Unix(0, atomic.LoadInt64(&last))) < idle {
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "No packets on %s for %v, reopening\n", device, idle)
+				mu.Lock()
+				if err == nil {
+					err = errIdle
+				}
+				mu.Unlock()
+				closeHandles(handles)
+				return
+			}
+		}()
+	}
+
+	sources := make([]<-chan gopacket.Packet, len(handles))
+	for i, handle := range handles {
+		// The link type cannot be read from the handle once another
+		// goroutine closes it
+		source := gopacket.NewPacketSource(handle, handle.LinkType())
+		packets := make(chan gopacket.Packet, 1000)
+		sources[i] = packets
+		go func() {
+			defer close(packets)
+			c, e := read(source, packets, &last)
+
+			mu.Lock()
+			captured = captured || c
+			if err == nil {
+				err = e
+			}
+			mu.Unlock()
+
+			// The others are of no use alone, e.g., queries without responses
+			if e != nil {
+				closeHandles(handles)
+			}
+		}()
+	}
+
+	// A handle delivers the packets buffered for up to the timeout at once,
+	// so one runs behind another by as much
+	packets := sources[0]
+	if len(sources) > 1 {
+		merged := make(chan gopacket.Packet, 1000)
+		go mergeByTime(sources, merged, 2*timeout)
+		packets = merged
+	}
+
+	interceptFrom("", iface, packets, exporters)
+	mu.Lock()
+	defer mu.Unlock()
+	return captured, err
+}
+
+// read sends the packets from the source to the channel until it reaches the
+// end or fails. It reports whether any packet was captured, and stores the
+// time of the last one in UnixNano to last unless nil.
+func read(source *gopacket.PacketSource, packets chan<- gopacket.Packet, last *int64) (bool, error) {
+	captured := false
+	for {
+		packet, err := source.NextPacket()
+		switch err {
+		case nil:
+			captured = true
+			if last != nil {
+				atomic.StoreInt64(last, time.Now().UnixNano())
+			}
+			ts := packet.Metadata().Timestamp
+			if !since.IsZero() && ts.Before(since) {
+				continue
+			}
+			// Packets in a pcap file are roughly in order of time, so nothing
+			// of interest follows
+			if !until.IsZero() && ts.After(until) {
+				return captured, nil
+			}
+			packets <- packet
+		case pcap.NextErrorTimeoutExpired:
+		case io.EOF:
+			return captured, nil
+		default:
+			return captured, err
+		}
+	}
+}
+
+// intercept passes the logs parsed from the packets to the exporters until the
+// channel is closed. Any source of packets works, not only a live capture.
+func intercept(packets <-chan gopacket.Packet, exporters []func(telescreenLog)) {
+	interceptFrom("", "", packets, exporters)
+}
+
+// interceptFrom is intercept tagging the logs with where the packets came
+// from before enrichers see them, i.e., the agent at the host that streamed
+// them with --listen-replay, or the interface they were captured on.
+func interceptFrom(host string, iface string, packets <-chan gopacket.Packet, exporters []func(telescreenLog)) {
+	defrag := newIP6Defragmenter(cacheEntries)
+	defer defrag.close()
+	for packet := range packets {
+		if packet = defrag.process(packet); packet == nil {
+			continue
+		}
+		log := parsePacket(packet)
+		if log != nil {
+			c := logCommon(log)
+			c.SourceHost, c.Interface = host, iface
+		}
+		if anonymizeFlag && log != nil {
+			anonymizeClient(log, anonymizeKey)
+		}
+		if log != nil {
+			if log = runEnrichers(packet, log); log == nil {
+				trace(packet, dropEnricher)
+			}
+		}
+		if combiner != nil {
+			if log != nil {
+				for _, l := range combiner.pass(log) {
+					if l = selectCombined(packet, l); l != nil {
+						export(l, exporters)
+					}
+				}
+			}
 			continue
-		case sniffFlag && has_aaaa_answer:
-			log = r
 		}
+		if ring != nil {
+			for _, l := range ring.pass(packet, log) {
+				export(l, exporters)
+			}
+			continue
+		}
+		if log == nil {
+			continue
+		}
+		export(log, exporters)
+	}
+}
 
-		for _, exporter := range exporters {
-			exporter(log)
+// export passes the log to the exporters unless it exceeds --max-rate.
+func export(log telescreenLog, exporters []func(telescreenLog)) {
+	if limiter != nil && !limiter.allow(time.Now()) {
+		return
+	}
+	for _, exporter := range exporters {
+		exporter(log)
+	}
+}
+
+// parsePacket returns the log to be exported for the packet, or nil if there
+// is nothing to export.
+func parsePacket(packet gopacket.Packet) telescreenLog {
+	c := newTelescreenLogCommon(packet)
+	if c == nil {
+		return nil
+	}
+	if dotFlag {
+		if d := newDoTLog(packet, c); d != nil {
+			return d
 		}
 	}
+	q := newQueryLog(packet, c)
+	if q == nil {
+		return nil
+	}
+	if n := countLabels(q.QString); (minLabels > 0 && n < minLabels) || (maxLabels > 0 && n > maxLabels) {
+		trace(packet, dropLabels)
+		return nil
+	}
+	if !selectsName(q.QString) {
+		trace(packet, dropName)
+		return nil
+	}
+	// The capture point may guarantee the direction when the automatic
+	// detection gets it wrong, e.g., one side of a mirrored port
+	forced := direction != "auto"
+	if forced {
+		q.isResponse = direction == "response"
+		q.setEndpoints(q.isResponse)
+	}
+	if latencies != nil {
+		latencies.observe(q)
+	}
+	r := newResponseLog(packet, q)
+
+	// The QR bit tells queries from responses, even when a client happens to
+	// use port 53 as its source port. Ports only ensure it is DNS traffic.
+	is_dns_port := forced || c.DstPort == 53 || c.SrcPort == 53
+	is_valid_response := is_dns_port && q.isResponse
+
+	switch {
+	case !is_dns_port:
+		trace(packet, dropPort)
+		return nil
+	case is_valid_response && combiner != nil:
+		// Paired with its query first, see selectCombined
+		return r
+	case is_valid_response && exportsResponse(r):
+		return filterResponse(packet, r)
+	case is_valid_response:
+		trace(packet, dropResponse)
+		return nil
+	case responsesOnly:
+		trace(packet, dropResponsesOnly)
+		return nil
+	}
+	if firstSeen != nil && !firstSeen.first(q.SrcIP, q.QString, q.Timestamp) {
+		trace(packet, dropFirstSeen)
+		return nil
+	}
+	if newNames != nil && !newNames.first(q.QString, q.Timestamp) {
+		trace(packet, dropSeenBefore)
+		return nil
+	}
+	return q
+}
+
+// exportsResponse reports whether the response is selected by the flags. The
+// older -A and --responses-only alone stand for --capture-responses
+// --response-types AAAA --drop-empty-response.
+func exportsResponse(r *ResponseLog) bool {
+	capture, types, empty := captureFlag, responseTypes, dropEmpty
+	if !capture && len(types) == 0 && (sniffFlag || responsesOnly) {
+		capture, types, empty = true, legacyResponseTypes, true
+	}
+
+	switch {
+	case !capture && len(types) == 0:
+		return false
+	case empty && !r.hasAnswer:
+		return false
+	case len(types) == 0:
+		return true
+	}
+	for _, t := range types {
+		if strings.EqualFold(t, r.QType) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectCombined applies the filters of responses to a log the combiner passed
+// for the packet. They apply after pairing, so that a query whose response is
+// not selected is dropped with it instead of timing out. Queries timed out are
+// selected as they are.
+func selectCombined(packet gopacket.Packet, log telescreenLog) telescreenLog {
+	var r *ResponseLog
+	switch l := log.(type) {
+	case *CombinedLog:
+		if l.Timeout {
+			return log
+		}
+		r = &l.ResponseLog
+	case *ResponseLog:
+		r = l
+	default:
+		return log
+	}
+	if !exportsResponse(r) {
+		trace(packet, dropResponse)
+		return nil
+	}
+	if filterResponse(packet, r) == nil {
+		return nil
+	}
+	return log
+}
+
+// countLabels counts the labels of the name, e.g., 3 for www.example.com. and 0
+// for the root.
+func countLabels(name string) int {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return 0
+	}
+	return strings.Count(name, ".") + 1
+}
+
+// selectsName reports whether the name matches any --include-regex, if given,
+// and none of --exclude-regex.
+func selectsName(name string) bool {
+	for _, re := range excludeNames {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(includeNames) == 0 {
+		return true
+	}
+	for _, re := range includeNames {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileNames compiles the patterns of names to match regardless of case.
+func compileNames(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
+// filterResponse drops the response unless it has one of the codes given by
+// --rcode, and its answer is in one of the networks given by --answer-cidr, if
+// any.
+func filterResponse(packet gopacket.Packet, r *ResponseLog) telescreenLog {
+	if len(rcodeFilter) > 0 && !selectsRcode(packet) {
+		trace(packet, dropRcode)
+		return nil
+	}
+	if len(answerNets) == 0 {
+		return r
+	}
+	for _, n := range answerNets {
+		if n.Contains(r.AnsIP) {
+			return r
+		}
+	}
+	trace(packet, dropAnswerCIDR)
+	return nil
+}
+
+// selectsRcode reports whether the response code of the packet is one of
+// --rcode.
+func selectsRcode(packet gopacket.Packet) bool {
+	dnsLayer := packet.Layer(layers.LayerTypeDNS)
+	if dnsLayer == nil {
+		return false
+	}
+	dns, _ := dnsLayer.(*layers.DNS)
+	for _, rcode := range rcodeFilter {
+		if dns.ResponseCode == rcode {
+			return true
+		}
+	}
+	return false
+}
+
+// listInterfaces prints the interfaces in a table like tcpdump -D does.
+func listInterfaces(w io.Writer, devs []pcap.Interface) {
+	if len(devs) == 0 {
+		fmt.Fprintln(w, "No interfaces found - capturing may require root or CAP_NET_RAW")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tDESCRIPTION\tADDRESSES")
+	for _, dev := range devs {
+		addrs := make([]string, len(dev.Addresses))
+		for i, addr := range dev.Addresses {
+			addrs[i] = addr.IP.String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", dev.Name, dev.Description, strings.Join(addrs, ", "))
+	}
+	tw.Flush()
+}
+
+// isPermissionError reports whether libpcap refused to open the device due to
+// lack of privileges, i.e., neither root nor CAP_NET_RAW.
+func isPermissionError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "permission") || strings.Contains(msg, "not permitted") || strings.Contains(msg, "access is denied")
+}
+
+// privilegeGuidance tells how to capture without the privileges on the OS,
+// since libpcap only says permission was denied.
+func privilegeGuidance(goos string, program string) string {
+	switch goos {
+	case "darwin":
+		return "Capturing requires read access to the BPF devices - run with sudo, or grant it to your user, e.g., by installing ChmodBPF of Wireshark or running sudo chmod o+r /dev/bpf*"
+	case "freebsd", "openbsd", "netbsd":
+		return "Capturing requires read access to the BPF devices - run with sudo or make /dev/bpf* readable by your group"
+	case "linux":
+		return fmt.Sprintf("Capturing requires root or CAP_NET_RAW - run with sudo or grant the capability: sudo setcap cap_net_raw,cap_net_admin=eip %s", program)
+	case "windows":
+		return "Capturing requires Npcap - install it, and run as administrator unless it was installed without the admin-only option"
+	default:
+		return "Capturing requires privileges - run as root or administrator"
+	}
+}
+
+// writeRunError writes why the run failed, with the guidance on the OS if it
+// lacks the privileges.
+func writeRunError(w io.Writer, err error, goos string, program string) {
+	fmt.Fprintf(w, "%v\n", err)
+	if isPermissionError(err) {
+		fmt.Fprintln(w, privilegeGuidance(goos, program))
+	}
 }
 
 func init() {
-	flag.StringVarP(&device, "dev", "i", "", "Interface name")
+	flag.StringVarP(&device, "dev", "i", "", "Interface name - any captures on all interfaces on Linux, and rpcap://host[:port]/iface on a remote one through rpcapd")
+	flag.StringVar(&captureHost, "capture-host", "", "Host name recorded with logs to tell sensors apart, along with the interface (default the hostname)")
+	flag.BoolVar(&showCapture, "show-capture-host", false, "Prefix lines with the capture host and interface, e.g., sensor1%eth0")
+	flag.StringVarP(&readFile, "read", "r", "", "Read packets from the specified pcap file instead of the interface")
+	flag.StringVar(&sinceFlag, "since", "", "With --read, skip packets captured before the time in RFC3339 (e.g., 2021-09-11T09:00:00+09:00)")
+	flag.StringVar(&untilFlag, "until", "", "With --read, stop at the first packet captured after the time in RFC3339")
+	flag.StringVar(&replayAddr, "listen-replay", "", "Accept pcap streams on the TCP address (e.g., :5300) instead of capturing, such as from tcpdump -w - | nc on agents, tagging logs with the agent address")
+	flag.IntVar(&replayConns, "replay-max-conns", 16, "Number of pcap streams handled at once with --listen-replay")
+	flag.StringVar(&rpcapUser, "rpcap-user", "", "Username to login to rpcapd with an rpcap:// --dev - null authentication (rpcapd -n) without it")
+	flag.StringVar(&rpcapPassword, "rpcap-password", "", "Password of --rpcap-user")
+	flag.IntVar(&bufferSize, "buffer-size", 0, "Kernel capture buffer size in bytes - increase it if packets are dropped (default libpcap's)")
+	flag.BoolVar(&splitFlag, "split-capture", false, "Capture queries and responses by two handles, each with its own snaplen - saves the buffer for queries while keeping large responses whole")
+	flag.IntVar(&querySnaplen, "query-snaplen", 512, "Snaplen of the query handle with --split-capture")
+	flag.IntVar(&respSnaplen, "response-snaplen", 65535, "Snaplen of the response handle with --split-capture")
+	flag.BoolVar(&reconnectFlag, "reconnect", false, "Reopen the interface with backoff when the capture ends unexpectedly, e.g., the interface went down")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 0, "Reopen the interface when no packets arrive for the duration (e.g., 5m), in case the driver stopped delivering them - 0 means never")
+	flag.IntVar(&reconnectMax, "reconnect-max", 0, "Give up after this many consecutive reconnect attempts - 0 means retrying forever")
+	flag.StringVar(&fqdnStyle, "fqdn-style", "strip", "Log names without or with the trailing dot - strip or keep, which --include-regex and --exclude-regex then see")
+	flag.StringVar(&direction, "dns-port-direction", "auto", "Treat every packet as a query or a response, overriding the detection by the QR bit and port 53 - auto, query or response")
 	flag.BoolVarP(&quietFlag, "quiet", "q", false, "Suppress standard output")
-	flag.BoolVarP(&sniffFlag, "with-response", "A", false, "Store responses to AAAA queries")
-	flag.StringVarP(&dbAddr, "db-host", "H", "", "Postgres server address to store logs (e.g., localhost:5432)")
+	flag.BoolVar(&captureFlag, "capture-responses", false, "Store responses as well as queries")
+	flag.StringSliceVar(&responseTypes, "response-types", nil, "Store only responses to the query types (e.g., A,AAAA) - implies --capture-responses")
+	flag.BoolVar(&dropEmpty, "drop-empty-response", false, "Do not store responses without an address, e.g., NXDOMAIN")
+	flag.BoolVarP(&sniffFlag, "with-response", "A", false, "Store responses to AAAA queries with an address - same as --capture-responses --response-types AAAA --drop-empty-response, kept for compatibility")
+	flag.BoolVar(&responsesOnly, "responses-only", false, "Store responses but not queries - implies --with-response unless --capture-responses or --response-types is given")
+	flag.BoolVar(&dotFlag, "dot", false, "Record connection attempts to DNS over TLS (port 853) along with their SNI")
+	flag.BoolVar(&traceFlag, "trace", false, "Print to stderr why each captured packet was dropped without a log - for troubleshooting")
+	flag.StringSliceVar(&rcodeNames, "rcode", nil, "Export only responses with the codes by name or number (e.g., NXDOMAIN,SERVFAIL), unlike --trigger-rcode - all codes by default")
+	flag.StringSliceVar(&answerCIDRs, "answer-cidr", nil, "Export only responses answering an address in the CIDR (e.g., 2001:db8::/32) - repeatable")
+	flag.IntVar(&minLabels, "min-qname-labels", 0, "Drop queries and responses for names with fewer labels (e.g., 2 drops TLD probes) - 0 means no limit")
+	flag.IntVar(&maxLabels, "max-qname-labels", 0, "Drop queries and responses for names with more labels - 0 means no limit")
+	flag.StringArrayVar(&includeRegexes, "include-regex", nil, "Export only queries and responses for names matching the regular expression, regardless of case - repeatable")
+	flag.StringArrayVar(&excludeRegexes, "exclude-regex", nil, "Drop queries and responses for names matching the regular expression (e.g., '^[a-z0-9]{32,}\\.') even if --include-regex matches - repeatable")
+	flag.BoolVar(&followCNAME, "follow-cname", false, "Log the address a CNAME chain in the response ends at, along with the number of CNAMEs, instead of the first CNAME")
+	flag.DurationVar(&firstInterval, "first-seen-interval", 0, "Log only the first query of each client for a name in the interval (e.g., 1h), for an overview of who asks for what - 0 means every query")
+	flag.BoolVar(&combinedFlag, "combined", false, "Log a query and its response as one record with the latency, or the query alone as timed out - implies --capture-responses")
+	flag.DurationVar(&combineWait, "combined-timeout", queryTimeout, "How long a query waits for its response with --combined")
+	flag.BoolVar(&newOnly, "only-new-domains", false, "Log a query only for a name never seen before, e.g., to detect newly observed domains - names least recently seen beyond --max-memory-cache-entries are forgotten")
+	flag.StringVar(&seenDB, "seen-db", "", "File keeping the names seen for --only-new-domains across restarts - in memory only if empty")
+	flag.DurationVar(&seenTTL, "seen-ttl", 0, "Names not seen for the duration (e.g., 720h) are new again for --only-new-domains - 0 means never")
+	flag.IntVar(&cacheEntries, "max-memory-cache-entries", maxTTLCacheEntries, "Entries each in-memory cache, e.g., of --detect-cached, --track-answer-churn, --metrics-addr and IPv6 reassembly, holds at most before evicting the least recently used")
+	flag.BoolVar(&churnFlag, "track-answer-churn", false, "Flag responses answering an address unlike those seen recently for the same name and type, e.g., cache poisoning or inconsistent load balancing")
+	flag.DurationVar(&churnWindow, "answer-churn-window", time.Hour, "How long an answer is remembered for --track-answer-churn")
+	flag.BoolVar(&cachedFlag, "detect-cached", false, "Flag responses likely served from a resolver cache, judging from decreasing TTLs")
+	flag.DurationVar(&flushInterval, "flush-interval", 0, "Buffer the standard output and flush it at the interval (e.g., 1s) - faster when piped, unbuffered by default")
+	flag.StringVar(&triggerRcode, "trigger-rcode", "", "Hold logs in memory and export them only around a response with the code (e.g., SERVFAIL)")
+	flag.StringVar(&triggerDomain, "trigger-domain", "", "Hold logs in memory and export them only around a query for a name under the domain")
+	flag.IntVar(&ringSize, "ring-size", 10000, "Number of logs held in memory for --trigger-rcode and --trigger-domain")
+	flag.DurationVar(&triggerWindow, "trigger-window", 10*time.Second, "How long logs are held before a trigger and passed through after it")
+	flag.BoolVar(&anonymizeFlag, "anonymize-client", false, "Zero the host portion of client addresses (last 64 bits of IPv6, last octet of IPv4) before exporting")
+	flag.StringVar(&anonymizeKey, "anonymize-key", "", "With --anonymize-client, replace the host portion with its HMAC-SHA256 by the key instead of zeroing")
+	flag.StringVar(&answerSelect, "answer-select", "first", "Answer logged when a response has several - first, last, or all to also print a line per address as --explode-answers does")
+	flag.BoolVar(&explodeFlag, "explode-answers", false, "Print a response with several addresses on as many lines, each with one of them, to the standard output and the log file")
+	flag.StringVarP(&logFile, "logfile", "o", "", "Append logs to the specified file")
+	flag.StringVarP(&logFormat, "format", "f", "text", "Log file format - text, json or csv with a header row")
+	flag.StringVar(&timeFormat, "time-format", "rfc3339", "Timestamps of the standard output and text log file - rfc3339, rfc3339nano, unix, unixnano or a Go layout (e.g., 15:04:05.000000)")
+	flag.StringVar(&protobufFile, "protobuf-out", "", "Append logs to the specified file as length-delimited DnsEvent messages of telescreen.proto")
+	flag.StringVar(&unixSocket, "unix-socket", "", "Write logs as JSON lines to the Unix domain socket at the path, which a local collector listens on")
+	flag.StringVar(&parquetFile, "parquet-out", "", "Write logs to Parquet files named after the path with a sequence number (e.g., logs.parquet makes logs-0001.parquet)")
+	flag.IntVar(&parquetRows, "parquet-max-rows", 1000000, "Roll to the next Parquet file after the number of rows - 0 means no limit")
+	flag.Int64Var(&parquetBytes, "parquet-max-bytes", 256*1024*1024, "Roll to the next Parquet file after about the size in bytes - 0 means no limit")
+	flag.StringVar(&grpcAddr, "grpc-addr", "", "Stream logs to gRPC subscribers listening on the address (e.g., :50051)")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics of resolution latencies at /metrics on the address (e.g., :9153)")
+	flag.StringVar(&compareAddr, "compare-resolver", "", "Look up the names of A and AAAA responses on the resolver (e.g., 2001:db8::53 or [2001:db8::53]:53) and log those responses only when it answers other addresses")
+	flag.Float64Var(&compareRate, "compare-rate", 10, "Look up names on --compare-resolver per second at most, skipping the excess")
+	flag.StringVar(&overflowPolicy, "overflow-policy", overflowDropNewest, "What to do when an output cannot keep up - block the capture (losing packets in the kernel instead), drop-newest or drop-oldest log")
+	flag.Float64Var(&maxRate, "max-rate", 0, "Export logs per second at most, dropping the excess - shared by all outputs, 0 means no limit")
+	flag.StringVar(&cpuProfile, "cpuprofile", "", "Write the CPU profile of the capture to the file - for go tool pprof")
+	flag.StringVar(&memProfile, "memprofile", "", "Write the heap profile to the file on exit - for go tool pprof")
+	flag.BoolVar(&benchFlag, "bench-sink", false, "Count logs in memory and report the throughput on exit - for benchmarking")
+	flag.StringVar(&dbDriver, "db-driver", "postgres", "Database to store logs - postgres or mysql (also for MariaDB)")
+	flag.StringVarP(&dbAddr, "db-host", "H", "", "Database server address to store logs (e.g., localhost:5432)")
 	flag.StringVarP(&dbName, "db-name", "N", "", "Database name to store")
 	flag.StringVarP(&dbUser, "db-user", "U", "", "Username to login")
 	flag.StringVarP(&dbPassFile, "db-password-file", "P", "", "Password to login - path of a plaintext password file")
 	flag.BoolVarP(&containerFlag, "container", "c", false, "Run inside a container - load options from environment variables")
+	flag.BoolVarP(&listFlag, "list-interfaces", "D", false, "List interfaces available for capturing")
 	flag.BoolVarP(&helpFlag, "help", "h", false, "Show help message")
 	flag.BoolVarP(&versionFlag, "version", "v", false, "Show build version")
 	flag.CommandLine.SortFlags = false
@@ -276,15 +1428,40 @@ func init() {
 
 func main() {
 	flag.Parse()
+	os.Exit(run())
+}
+
+// run returns the exit code rather than exiting, so that the exporters are
+// closed on the way out whatever happens.
+func run() (exitCode int) {
+	// An exporter giving up stops the capture cleanly, but still fails
+	defer func() {
+		if exitCode == 0 && atomic.LoadUint32(&exporterGaveUp) != 0 {
+			exitCode = 1
+		}
+	}()
 
 	exporters := []func(telescreenLog){}
 
 	if containerFlag {
 		device = os.Getenv("TELESCREEN_DEVICE")
 		dbAddr = os.Getenv("TELESCREEN_DB_HOST")
+		if driver := os.Getenv("TELESCREEN_DB_DRIVER"); driver != "" {
+			dbDriver = driver
+		}
 		dbName = os.Getenv("TELESCREEN_DB_NAME")
 		dbUser = os.Getenv("TELESCREEN_DB_USER")
 		dbPassFile = os.Getenv("TELESCREEN_DB_PASSWORD_FILE")
+		logFile = os.Getenv("TELESCREEN_LOGFILE")
+		protobufFile = os.Getenv("TELESCREEN_PROTOBUF_OUT")
+		grpcAddr = os.Getenv("TELESCREEN_GRPC_ADDR")
+		metricsAddr = os.Getenv("TELESCREEN_METRICS_ADDR")
+		if host := os.Getenv("TELESCREEN_CAPTURE_HOST"); host != "" {
+			captureHost = host
+		}
+		if format := os.Getenv("TELESCREEN_LOGFILE_FORMAT"); format != "" {
+			logFormat = format
+		}
 		quietFlag = true
 		switch os.Getenv("TELESCREEN_STORE_RESPONSES") {
 		case "yes", "Yes", "YES", "true", "True", "TRUE":
@@ -294,43 +1471,377 @@ func main() {
 		}
 	}
 
+	// Left empty if the hostname is unknown, which is only a label anyway
+	if captureHost == "" {
+		captureHost, _ = os.Hostname()
+	}
+
 	if versionFlag {
 		fmt.Println(VERSION + "-" + REVISION)
-		os.Exit(0)
+		return 0
 	}
 
-	show_help := helpFlag || device == ""
+	if listFlag {
+		devs, err := pcap.FindAllDevs()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list interfaces: %v\n", err)
+			return 1
+		}
+		listInterfaces(os.Stdout, devs)
+		return 0
+	}
+
+	show_help := helpFlag || (device == "" && readFile == "" && replayAddr == "")
 	if show_help {
 		flag.PrintDefaults()
-		os.Exit(0)
+		return 0
+	}
+
+	switch direction {
+	case "auto", "query", "response":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown --dns-port-direction: %s\n", direction)
+		return 1
 	}
 
-	if !quietFlag {
-		exporters = append(exporters, stdExporter)
+	switch fqdnStyle {
+	case "strip", "keep":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown --fqdn-style: %s\n", fqdnStyle)
+		return 1
 	}
 
-	use_psql := dbAddr != "" && dbName != "" && dbUser != "" && dbPassFile != ""
-	if use_psql {
+	switch answerSelect {
+	case "first", "last":
+	case "all":
+		explodeFlag = true
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown --answer-select: %s\n", answerSelect)
+		return 1
+	}
+
+	if replayAddr != "" && (device != "" || readFile != "") {
+		fmt.Fprintf(os.Stderr, "--listen-replay cannot be used with --dev or --read\n")
+		return 1
+	}
+	if replayAddr != "" && replayConns <= 0 {
+		fmt.Fprintf(os.Stderr, "--replay-max-conns must be positive\n")
+		return 1
+	}
+
+	if splitFlag && readFile != "" {
+		fmt.Fprintf(os.Stderr, "--split-capture cannot be used with --read\n")
+		return 1
+	}
+
+	if (sinceFlag != "" || untilFlag != "") && readFile == "" {
+		fmt.Fprintf(os.Stderr, "--since and --until require --read\n")
+		return 1
+	}
+	if sinceFlag != "" {
+		if since, err = time.Parse(time.RFC3339, sinceFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse --since: %v\n", err)
+			return 1
+		}
+	}
+	if untilFlag != "" {
+		if until, err = time.Parse(time.RFC3339, untilFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse --until: %v\n", err)
+			return 1
+		}
+	}
+
+	for _, cidr := range answerCIDRs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse --answer-cidr: %v\n", err)
+			return 1
+		}
+		answerNets = append(answerNets, n)
+	}
+
+	if isRemoteDevice(device) {
+		if _, err := parseRPCAP(device); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse --dev: %v\n", err)
+			return 1
+		}
+		if bufferSize > 0 {
+			fmt.Fprintf(os.Stderr, "--buffer-size cannot be set for rpcap sources\n")
+			return 1
+		}
+	}
+	if rpcapPassword != "" && rpcapUser == "" {
+		fmt.Fprintf(os.Stderr, "--rpcap-password requires --rpcap-user\n")
+		return 1
+	}
+
+	if includeNames, err = compileNames(includeRegexes); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse --include-regex: %v\n", err)
+		return 1
+	}
+	if excludeNames, err = compileNames(excludeRegexes); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse --exclude-regex: %v\n", err)
+		return 1
+	}
+
+	for _, name := range rcodeNames {
+		rcode, err := parseRcode(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse --rcode: %v\n", err)
+			return 1
+		}
+		rcodeFilter = append(rcodeFilter, rcode)
+	}
+
+	if triggerRcode != "" || triggerDomain != "" {
+		if ringSize <= 0 {
+			fmt.Fprintf(os.Stderr, "--ring-size must be positive\n")
+			return 1
+		}
+		ring = newTriggerRing(ringSize, triggerWindow)
+		ring.domain = strings.ToLower(strings.TrimSuffix(triggerDomain, "."))
+		if triggerRcode != "" {
+			if ring.rcode, err = parseRcode(triggerRcode); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to parse --trigger-rcode: %v\n", err)
+				return 1
+			}
+			ring.rcodes = true
+		}
+	}
+
+	// A layout without any element or named like a preset is more likely a
+	// mistyped preset, e.g., rfc3339nan
+	switch timeFormat {
+	case "rfc3339", "rfc3339nano", "unix", "unixnano":
+	default:
+		if strings.HasPrefix(timeFormat, "rfc") || strings.HasPrefix(timeFormat, "unix") || formatTime(time.Unix(0, 0)) == timeFormat {
+			fmt.Fprintf(os.Stderr, "Unknown --time-format: %s\n", timeFormat)
+			return 1
+		}
+	}
+
+	switch overflowPolicy {
+	case overflowBlock, overflowDropNewest, overflowDropOldest:
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown --overflow-policy: %s\n", overflowPolicy)
+		return 1
+	}
+
+	if cacheEntries <= 0 {
+		fmt.Fprintf(os.Stderr, "--max-memory-cache-entries must be positive\n")
+		return 1
+	}
+	if cachedFlag {
+		ttlCache = newMaxTTLCache(cacheEntries)
+	}
+	if churnFlag {
+		answerChurn = newAnswerHistory(cacheEntries, churnWindow)
+	}
+	if firstInterval < 0 {
+		fmt.Fprintf(os.Stderr, "--first-seen-interval must not be negative\n")
+		return 1
+	}
+	if firstInterval > 0 {
+		firstSeen = newFirstSeenFilter(cacheEntries, firstInterval)
+	}
+	if combinedFlag {
+		// The comparer takes responses alone
+		if ring != nil || responsesOnly || compareAddr != "" {
+			fmt.Fprintf(os.Stderr, "--combined cannot be used with --trigger-rcode, --trigger-domain, --responses-only or --compare-resolver\n")
+			return 1
+		}
+		if combineWait <= 0 {
+			fmt.Fprintf(os.Stderr, "--combined-timeout must be positive\n")
+			return 1
+		}
+		captureFlag = true
+		combiner = newQueryCombiner(cacheEntries, combineWait)
+	}
+	if newOnly {
+		if newNames, err = openSeenDomains(seenDB, cacheEntries, seenTTL); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open --seen-db: %v\n", err)
+			return 1
+		}
+		defer func() {
+			if err := newNames.close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write --seen-db: %v\n", err)
+			}
+		}()
+	}
+
+	if maxRate < 0 {
+		fmt.Fprintf(os.Stderr, "--max-rate must not be negative\n")
+		return 1
+	}
+	if idleTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "--idle-timeout must not be negative\n")
+		return 1
+	}
+	if compareAddr != "" && compareRate <= 0 {
+		fmt.Fprintf(os.Stderr, "--compare-rate must be positive\n")
+		return 1
+	}
+	defer writeDropSummary(os.Stderr)
+
+	if maxRate > 0 {
+		limiter = newRateLimiter(maxRate)
+		defer func() {
+			if n := limiter.droppedLogs(); n > 0 {
+				fmt.Fprintf(os.Stderr, "Dropped %d logs over --max-rate\n", n)
+			}
+		}()
+	}
+
+	// Every exporter but the bench sink runs behind its own queue, drained
+	// before the exporters are closed
+	drains := []func(){}
+	isolated := func(name string, exporter func(telescreenLog)) func(telescreenLog) {
+		e, drain := isolateExporter(name, exporter, exportQueueSize, overflowPolicy)
+		drains = append(drains, drain)
+		return e
+	}
+
+	// Only the outputs read by people are given a line per answer
+	perAnswer := func(exporter func(telescreenLog)) func(telescreenLog) {
+		if explodeFlag {
+			return explodeAnswers(exporter)
+		}
+		return exporter
+	}
+
+	if !quietFlag && flushInterval > 0 {
+		bufferedExporter, bufferedCloser := newBufferedStdExporter(flushInterval)
+		exporters = append(exporters, isolated("stdout", perAnswer(bufferedExporter)))
+		defer bufferedCloser()
+	} else if !quietFlag {
+		exporters = append(exporters, isolated("stdout", perAnswer(stdExporter)))
+	}
+
+	if logFile != "" {
+		fileExporter, fileCloser, err := newFileExporter(logFile, logFormat)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open log file: %v\n", err)
+			return 1
+		}
+		exporters = append(exporters, isolated("log file", perAnswer(fileExporter)))
+		defer fileCloser()
+	}
+
+	if protobufFile != "" {
+		protobufExporter, protobufCloser, err := newProtobufExporter(protobufFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open protobuf file: %v\n", err)
+			return 1
+		}
+		exporters = append(exporters, isolated("protobuf", protobufExporter))
+		defer protobufCloser()
+	}
+
+	if unixSocket != "" {
+		unixExporter, unixCloser, err := newUnixSocketExporter(unixSocket)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to connect to Unix socket: %v\n", err)
+			return 1
+		}
+		exporters = append(exporters, isolated("Unix socket", unixExporter))
+		defer unixCloser()
+	}
+
+	if parquetFile != "" {
+		parquetExporter, parquetCloser, err := newParquetExporter(parquetFile, parquetRows, parquetBytes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open Parquet file: %v\n", err)
+			return 1
+		}
+		exporters = append(exporters, isolated("Parquet", parquetExporter))
+		defer parquetCloser()
+	}
+
+	if benchFlag {
+		benchExporter, benchCloser := newBenchExporter()
+		exporters = append(exporters, benchExporter)
+		defer benchCloser()
+	}
+
+	if grpcAddr != "" {
+		grpcExporter, grpcCloser, err := newGRPCExporter(grpcAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to start gRPC server: %v\n", err)
+			return 1
+		}
+		exporters = append(exporters, isolated("gRPC", grpcExporter))
+		defer grpcCloser()
+	}
+
+	if metricsAddr != "" {
+		latencies = newLatencyMetrics(cacheEntries)
+		metricsCloser, err := serveMetrics(latencies, metricsAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to start metrics server: %v\n", err)
+			return 1
+		}
+		defer metricsCloser()
+	}
+
+	use_db := dbAddr != "" && dbName != "" && dbUser != "" && dbPassFile != ""
+	if use_db {
 		f, err := os.Open(dbPassFile)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to open password file for DB login: %v\n", err)
-			os.Exit(1)
+			return 1
 		}
 		defer f.Close()
 
 		b, err := ioutil.ReadAll(f)
 		password := string(b)
-		dbExporter, dbCloser := newDBExporter(&pg.Options{
-			Addr:     dbAddr,
-			User:     dbUser,
-			Password: password,
-			Database: dbName,
-		})
+		backend, err := newDBBackend(dbDriver, dbAddr, dbName, dbUser, password)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to prepare database connection: %v\n", err)
+			return 1
+		}
+		dbExporter, dbCloser := newDBExporter(backend)
 
 		fmt.Printf("Prepared database connection: %s", dbAddr)
-		exporters = append(exporters, dbExporter)
+		exporters = append(exporters, isolated("database", dbExporter))
 		defer dbCloser()
 	}
 
-	telescreen(exporters)
+	defer func() {
+		for _, drain := range drains {
+			drain()
+		}
+	}()
+
+	// Lookups still running are waited for before the queues are drained, and
+	// the comparer passes the logs it does not compare on to the exporters
+	if compareAddr != "" {
+		comparer := newResolverComparer(compareAddr, compareRate, exporters)
+		defer comparer.close()
+		exporters = []func(telescreenLog){comparer.submit}
+	}
+
+	// The capture returns on a signal too, so the profiles are complete
+	stopProfiles, err := startProfiles(cpuProfile, memProfile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start profiling: %v\n", err)
+		return 1
+	}
+	defer stopProfiles()
+
+	start := telescreen
+	if replayAddr != "" {
+		start = replay
+	}
+	err = start(exporters)
+	// Queries still waiting will never be answered
+	if combiner != nil {
+		for _, l := range combiner.flush() {
+			export(l, exporters)
+		}
+	}
+	if err != nil {
+		writeRunError(os.Stderr, err, runtime.GOOS, os.Args[0])
+		return 2
+	}
+	return 0
 }
@@ -0,0 +1,1117 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+const (
+	testClient string = "2001:db8::1"
+	testServer string = "2001:db8::53"
+)
+
+// newDNSPacket builds an Ethernet frame carrying the DNS message over UDP,
+// after the IPv6 extension headers if any.
+func newDNSPacket(tb testing.TB, ext []gopacket.SerializableLayer, src, dst string, sport, dport uint16, dns *layers.DNS) gopacket.Packet {
+	tb.Helper()
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, DstMAC: net.HardwareAddr{0, 1, 2, 3, 4, 6}, EthernetType: layers.EthernetTypeIPv6}
+	ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP, SrcIP: net.ParseIP(src), DstIP: net.ParseIP(dst)}
+	if len(ext) > 0 {
+		ip6.NextHeader = layers.IPProtocolIPv6HopByHop
+	}
+	udp := &layers.UDP{SrcPort: layers.UDPPort(sport), DstPort: layers.UDPPort(dport)}
+	udp.SetNetworkLayerForChecksum(ip6)
+
+	ls := append([]gopacket.SerializableLayer{eth, ip6}, ext...)
+	ls = append(ls, udp, dns)
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ls...); err != nil {
+		tb.Fatal(err)
+	}
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	packet.Metadata().Timestamp = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return packet
+}
+
+// newQueryPacket builds the query from the client to the server.
+func newQueryPacket(tb testing.TB, dns *layers.DNS) gopacket.Packet {
+	tb.Helper()
+	return newDNSPacket(tb, nil, testClient, testServer, 40000, 53, dns)
+}
+
+// newResponsePacket builds the response from the server to the client, after
+// the query by the delay.
+func newResponsePacket(tb testing.TB, dns *layers.DNS, delay time.Duration) gopacket.Packet {
+	tb.Helper()
+	packet := newDNSPacket(tb, nil, testServer, testClient, 53, 40000, dns)
+	packet.Metadata().Timestamp = packet.Metadata().Timestamp.Add(delay)
+	return packet
+}
+
+func query(name string, qtype layers.DNSType) *layers.DNS {
+	return &layers.DNS{ID: 0x1234, RD: true, Questions: []layers.DNSQuestion{{Name: []byte(name), Type: qtype, Class: layers.DNSClassIN}}}
+}
+
+func response(name string, qtype layers.DNSType, answers ...layers.DNSResourceRecord) *layers.DNS {
+	dns := query(name, qtype)
+	dns.QR = true
+	dns.RA = true
+	dns.Answers = answers
+	return dns
+}
+
+func aaaa(name, ip string, ttl uint32) layers.DNSResourceRecord {
+	return layers.DNSResourceRecord{Name: []byte(name), Type: layers.DNSTypeAAAA, Class: layers.DNSClassIN, TTL: ttl, IP: net.ParseIP(ip)}
+}
+
+func cname(name, target string) layers.DNSResourceRecord {
+	return layers.DNSResourceRecord{Name: []byte(name), Type: layers.DNSTypeCNAME, Class: layers.DNSClassIN, TTL: 300, CNAME: []byte(target)}
+}
+
+func a(name, ip string) layers.DNSResourceRecord {
+	return layers.DNSResourceRecord{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 300, IP: net.ParseIP(ip).To4()}
+}
+
+// interceptAll runs the packets through intercept and returns the logs
+// exported.
+func interceptAll(packets ...gopacket.Packet) []telescreenLog {
+	ch := make(chan gopacket.Packet, len(packets))
+	for _, packet := range packets {
+		ch <- packet
+	}
+	close(ch)
+
+	var logs []telescreenLog
+	intercept(ch, []func(telescreenLog){func(l telescreenLog) { logs = append(logs, l) }})
+	return logs
+}
+
+// BenchmarkInterceptorParse measures the hot path from decoded packets to an
+// exporter doing nothing, as --bench-sink does on a live capture.
+func BenchmarkInterceptorParse(b *testing.B) {
+	defer func(sniff bool) { sniffFlag = sniff }(sniffFlag)
+	sniffFlag = true
+
+	packets := []gopacket.Packet{
+		newQueryPacket(b, query("www.example.com", layers.DNSTypeAAAA)),
+		newResponsePacket(b, response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300)), time.Millisecond),
+		newQueryPacket(b, query("example.net", layers.DNSTypeA)),
+		newResponsePacket(b, response("example.net", layers.DNSTypeA), time.Millisecond),
+	}
+	exporter, _ := newBenchExporter()
+
+	ch := make(chan gopacket.Packet, 1024)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			ch <- packets[i%len(packets)]
+		}
+		close(ch)
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	intercept(ch, []func(telescreenLog){exporter})
+}
+
+func TestHopByHopHeader(t *testing.T) {
+	hbh := &layers.IPv6HopByHop{}
+	hbh.NextHeader = layers.IPProtocolUDP
+	hbh.Options = []*layers.IPv6HopByHopOption{{OptionType: 1, OptionData: []byte{0, 0, 0, 0}}}
+	packet := newDNSPacket(t, []gopacket.SerializableLayer{hbh}, testClient, testServer, 40000, 53, query("www.example.com", layers.DNSTypeAAAA))
+
+	c := newTelescreenLogCommon(packet)
+	if c == nil {
+		t.Fatal("transport after a Hop-by-Hop header not found")
+	}
+	q := newQueryLog(packet, c)
+	if q == nil {
+		t.Fatal("question after a Hop-by-Hop header not found")
+	}
+	if q.DstPort != 53 || q.QString != "www.example.com" || q.QType != "AAAA" {
+		t.Errorf("query to port %d for %s %s, want port 53 for www.example.com AAAA", q.DstPort, q.QString, q.QType)
+	}
+}
+
+func TestLinuxCookedHeader(t *testing.T) {
+	// The "any" device gives the packets the Linux cooked header instead of
+	// the Ethernet one
+	packet := newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA))
+	sll := []byte{0, 0, 0, 1, 0, 6, 0, 1, 2, 3, 4, 5, 0, 0, 0x86, 0xdd}
+	data := append(sll, packet.NetworkLayer().LayerContents()...)
+	data = append(data, packet.NetworkLayer().LayerPayload()...)
+	cooked := gopacket.NewPacket(data, layers.LinkTypeLinuxSLL, gopacket.Default)
+
+	logs := interceptAll(cooked)
+	if len(logs) != 1 {
+		t.Fatalf("exported %d logs, want 1", len(logs))
+	}
+	if q, ok := logs[0].(*QueryLog); !ok || q.QString != "www.example.com" || q.SrcIP.String() != testClient {
+		t.Errorf("exported %v, want the query from %s", logs[0], testClient)
+	}
+}
+
+func TestNoInterfaceError(t *testing.T) {
+	defer func(d string) { device = d }(device)
+	device = "telescreen-test0"
+
+	err := telescreen(nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "Failed to start capturing") {
+		t.Errorf("telescreen() = %v, want failing to start capturing", err)
+	}
+	if isPermissionError(err) {
+		t.Errorf("a missing interface taken for a lack of privileges: %v", err)
+	}
+}
+
+func TestAnswerTypes(t *testing.T) {
+	dns := response("www.example.com", layers.DNSTypeA,
+		cname("www.example.com", "cdn.example.net"),
+		a("cdn.example.net", "192.0.2.1"),
+		a("cdn.example.net", "192.0.2.2"),
+	)
+	packet := newResponsePacket(t, dns, 0)
+	r := newResponseLog(packet, newQueryLog(packet, newTelescreenLogCommon(packet)))
+	if r == nil {
+		t.Fatal("response not parsed")
+	}
+	if r.AnsTypes != "1xCNAME, 2xA" {
+		t.Errorf("answer types %q, want %q", r.AnsTypes, "1xCNAME, 2xA")
+	}
+	if !strings.Contains(r.String(), "[1xCNAME, 2xA]") {
+		t.Errorf("answer types not rendered: %s", r.String())
+	}
+}
+
+func TestAnswerSection(t *testing.T) {
+	referral := response("www.example.com", layers.DNSTypeAAAA)
+	referral.Authorities = []layers.DNSResourceRecord{{Name: []byte("example.com"), Type: layers.DNSTypeNS, Class: layers.DNSClassIN, TTL: 300, NS: []byte("ns1.example.com")}}
+	referral.Additionals = []layers.DNSResourceRecord{aaaa("ns1.example.com", "2001:db8::35", 300)}
+	tests := []struct {
+		name    string
+		dns     *layers.DNS
+		ip      string
+		section string
+	}{
+		{"answer", response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300)), "2001:db8::80", "ANSWER"},
+		{"glue only", referral, "2001:db8::35", "ADDITIONAL"},
+	}
+	for _, tt := range tests {
+		packet := newResponsePacket(t, tt.dns, 0)
+		r := newResponseLog(packet, newQueryLog(packet, newTelescreenLogCommon(packet)))
+		if r == nil {
+			t.Fatalf("%s: response not parsed", tt.name)
+		}
+		if r.AnsIP.String() != tt.ip || r.AnsSection != tt.section {
+			t.Errorf("%s: answer %v in %s, want %s in %s", tt.name, r.AnsIP, r.AnsSection, tt.ip, tt.section)
+		}
+		if tt.section != "ANSWER" && !strings.Contains(r.String(), "in "+tt.section) {
+			t.Errorf("%s: section not rendered: %s", tt.name, r.String())
+		}
+	}
+}
+
+func TestAnswerSelect(t *testing.T) {
+	defer func(selected string) { answerSelect = selected }(answerSelect)
+
+	dns := response("www.example.com", layers.DNSTypeAAAA,
+		aaaa("www.example.com", "2001:db8::80", 300),
+		aaaa("www.example.com", "64:ff9b::c000:203", 300),
+	)
+	tests := []struct {
+		selected string
+		ip       string
+		ready    bool
+	}{
+		{"first", "2001:db8::80", true},
+		{"last", "64:ff9b::c000:203", false},
+	}
+	for _, tt := range tests {
+		answerSelect = tt.selected
+		packet := newResponsePacket(t, dns, 0)
+		r := newResponseLog(packet, newQueryLog(packet, newTelescreenLogCommon(packet)))
+		if r == nil {
+			t.Fatalf("%s: response not parsed", tt.selected)
+		}
+		if r.AnsIP.String() != tt.ip || r.IPv6Ready != tt.ready || r.AnsTypes != "2xAAAA" {
+			t.Errorf("%s: got %v (IPv6 ready %v) of %s, want %s (%v) of 2xAAAA", tt.selected, r.AnsIP, r.IPv6Ready, r.AnsTypes, tt.ip, tt.ready)
+		}
+	}
+}
+
+func TestServerID(t *testing.T) {
+	tests := []struct {
+		name string
+		nsid []byte
+		want string
+	}{
+		{"ascii", []byte("tyo1.example"), "tyo1.example"},
+		{"binary", []byte{0xde, 0xad, 0x00, 0x01}, "dead0001"},
+		{"no option", nil, ""},
+	}
+	for _, tt := range tests {
+		dns := response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300))
+		opt := layers.DNSResourceRecord{Type: layers.DNSTypeOPT, Class: 4096}
+		if tt.nsid != nil {
+			opt.OPT = []layers.DNSOPT{{Code: layers.DNSOptionCodeNSID, Data: tt.nsid}}
+		}
+		dns.Additionals = []layers.DNSResourceRecord{opt}
+		packet := newResponsePacket(t, dns, 0)
+		r := newResponseLog(packet, newQueryLog(packet, newTelescreenLogCommon(packet)))
+		if r == nil {
+			t.Fatalf("%s: response not parsed", tt.name)
+		}
+		if r.ServerID != tt.want {
+			t.Errorf("%s: got server ID %q, want %q", tt.name, r.ServerID, tt.want)
+		}
+		if rendered := strings.Contains(r.String(), ", NSID "); rendered != (tt.want != "") {
+			t.Errorf("%s: NSID rendered %v: %s", tt.name, rendered, r.String())
+		}
+	}
+}
+
+func TestFollowCNAME(t *testing.T) {
+	defer func(follow bool) { followCNAME = follow }(followCNAME)
+
+	// Records of the chain are not necessarily in order
+	chain := response("www.example.com", layers.DNSTypeA,
+		cname("cdn.example.net", "edge.example.org"),
+		cname("www.example.com", "cdn.example.net"),
+		a("edge.example.org", "192.0.2.80"),
+	)
+	loop := response("www.example.com", layers.DNSTypeA,
+		cname("www.example.com", "cdn.example.net"),
+		cname("cdn.example.net", "www.example.com"),
+	)
+	tests := []struct {
+		name   string
+		follow bool
+		dns    *layers.DNS
+		ip     string
+		hops   uint16
+	}{
+		{"two hops", true, chain, "192.0.2.80", 2},
+		{"not followed", false, chain, "<nil>", 0},
+		{"loop", true, loop, "<nil>", 0},
+		{"no CNAME", true, response("www.example.com", layers.DNSTypeA, a("www.example.com", "192.0.2.1")), "192.0.2.1", 0},
+	}
+	for _, tt := range tests {
+		followCNAME = tt.follow
+		packet := newResponsePacket(t, tt.dns, 0)
+		r := newResponseLog(packet, newQueryLog(packet, newTelescreenLogCommon(packet)))
+		if r == nil {
+			t.Fatalf("%s: response not parsed", tt.name)
+		}
+		if r.QString != "www.example.com" || r.AnsIP.String() != tt.ip || r.CNAMEHops != tt.hops {
+			t.Errorf("%s: got %s at %v via %d CNAMEs, want www.example.com at %s via %d", tt.name, r.QString, r.AnsIP, r.CNAMEHops, tt.ip, tt.hops)
+		}
+		if tt.hops > 0 && !strings.Contains(r.String(), "via 2 CNAMEs") {
+			t.Errorf("%s: hops not rendered: %s", tt.name, r.String())
+		}
+	}
+}
+
+func TestTransactionID(t *testing.T) {
+	defer func(sniff bool) { sniffFlag = sniff }(sniffFlag)
+	sniffFlag = true
+
+	qdns := query("www.example.com", layers.DNSTypeAAAA)
+	qdns.ID = 0xbeef
+	rdns := response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300))
+	rdns.ID = 0xbeef
+	for _, l := range []telescreenLog{parsePacket(newQueryPacket(t, qdns)), parsePacket(newResponsePacket(t, rdns, 0))} {
+		var q *QueryLog
+		switch l := l.(type) {
+		case *QueryLog:
+			q = l
+		case *ResponseLog:
+			q = &l.QueryLog
+		default:
+			t.Fatalf("parsed as %T", l)
+		}
+		if q.TransID != 0xbeef || !strings.Contains(l.String(), " 48879 ") {
+			t.Errorf("transaction ID %d rendered %q, want 48879", q.TransID, l.String())
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	defer func(capture bool) { captureFlag = capture }(captureFlag)
+	captureFlag = true
+
+	notify := query("example.com", layers.DNSTypeSOA)
+	notify.OpCode, notify.AA = layers.DNSOpCodeNotify, true
+	ack := response("example.com", layers.DNSTypeSOA)
+	ack.OpCode, ack.AA = layers.DNSOpCodeNotify, true
+	logs := interceptAll(newQueryPacket(t, notify), newResponsePacket(t, ack, 0))
+	if len(logs) != 2 {
+		t.Fatalf("got %d logs, want the NOTIFY and its acknowledgement", len(logs))
+	}
+	for _, l := range logs {
+		var q *QueryLog
+		switch l := l.(type) {
+		case *QueryLog:
+			q = l
+		case *ResponseLog:
+			q = &l.QueryLog
+			if l.AnsTypes != "" || l.AnsIP != nil {
+				t.Errorf("acknowledgement has answers %q %v", l.AnsTypes, l.AnsIP)
+			}
+		default:
+			t.Fatalf("parsed as %T", l)
+		}
+		if q.Opcode != "NOTIFY" || q.QString != "example.com" || q.QType != "SOA" {
+			t.Errorf("got %s for %s %s, want NOTIFY for example.com SOA", q.Opcode, q.QString, q.QType)
+		}
+		if !strings.Contains(l.String(), "NOTIFY example.com") {
+			t.Errorf("opcode not rendered: %s", l.String())
+		}
+	}
+
+	// A standard query does not show its opcode
+	q := parsePacket(newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA))).(*QueryLog)
+	if q.Opcode != "QUERY" || strings.Contains(q.String(), "QUERY") {
+		t.Errorf("got opcode %s rendered %q", q.Opcode, q.String())
+	}
+}
+
+func TestQRBitOverPort(t *testing.T) {
+	defer func(sniff bool) { sniffFlag = sniff }(sniffFlag)
+	sniffFlag = true
+
+	answered := response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300))
+	tests := []struct {
+		name         string
+		sport, dport uint16
+		dns          *layers.DNS
+		response     bool
+	}{
+		{"query from port 53", 53, 53, query("www.example.com", layers.DNSTypeAAAA), false},
+		{"query to a client port", 53, 40000, query("www.example.com", layers.DNSTypeAAAA), false},
+		{"response to port 53", 53, 53, answered, true},
+		{"response from a client port", 40000, 53, answered, true},
+	}
+	for _, tt := range tests {
+		packet := newDNSPacket(t, nil, testClient, testServer, tt.sport, tt.dport, tt.dns)
+		switch l := parsePacket(packet).(type) {
+		case *ResponseLog:
+			if !tt.response {
+				t.Errorf("%s: parsed as a response", tt.name)
+			}
+		case *QueryLog:
+			if tt.response {
+				t.Errorf("%s: parsed as a query", tt.name)
+			}
+		default:
+			t.Errorf("%s: parsed as %T", tt.name, l)
+		}
+	}
+}
+
+func TestForcedDirection(t *testing.T) {
+	defer func(only bool, d string) { responsesOnly, direction = only, d }(responsesOnly, direction)
+	responsesOnly = true
+
+	// An answer from the server with the QR bit cleared, e.g., by a broken
+	// middlebox, looks like a query
+	dns := response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300))
+	dns.QR = false
+	packet := newResponsePacket(t, dns, 0)
+
+	direction = "auto"
+	if l := parsePacket(packet); l != nil {
+		t.Errorf("parsed %T with --responses-only, want it ignored as a query", l)
+	}
+	direction = "response"
+	r, ok := parsePacket(packet).(*ResponseLog)
+	if !ok {
+		t.Fatal("response not parsed in response mode")
+	}
+	if r.ClientIP.String() != testClient || r.ServerIP.String() != testServer || r.AnsIP.String() != "2001:db8::80" {
+		t.Errorf("client %v and server %v answered %v, want %s and %s answered 2001:db8::80", r.ClientIP, r.ServerIP, r.AnsIP, testClient, testServer)
+	}
+}
+
+// fakeHandle replays the packets, then ends with the error like a pcap handle
+// whose device went away, or with blocking until closed like a quiet one.
+type fakeHandle struct {
+	packets  []gopacket.Packet
+	err      error
+	blocking bool
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newFakeHandle(err error, packets ...gopacket.Packet) *fakeHandle {
+	return &fakeHandle{packets: packets, err: err, closed: make(chan struct{})}
+}
+
+func (h *fakeHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if len(h.packets) == 0 {
+		if h.blocking {
+			<-h.closed
+			return nil, gopacket.CaptureInfo{}, io.EOF
+		}
+		return nil, gopacket.CaptureInfo{}, h.err
+	}
+	packet := h.packets[0]
+	h.packets = h.packets[1:]
+	data := packet.Data()
+	return data, gopacket.CaptureInfo{Timestamp: packet.Metadata().Timestamp, CaptureLength: len(data), Length: len(data)}, nil
+}
+
+func (h *fakeHandle) LinkType() layers.LinkType { return layers.LinkTypeEthernet }
+func (h *fakeHandle) Close()                    { h.closeOnce.Do(func() { close(h.closed) }) }
+
+func TestCaptureReconnects(t *testing.T) {
+	defer func(reconnect bool, max int) { reconnectFlag, reconnectMax = reconnect, max }(reconnectFlag, reconnectMax)
+	reconnectFlag, reconnectMax = true, 1
+
+	// The device goes down after a query, comes back for another, and then
+	// never again
+	opened := 0
+	open := func() ([]captureHandle, error) {
+		opened += 1
+		switch opened {
+		case 1:
+			return []captureHandle{newFakeHandle(errors.New("device went down"), newQueryPacket(t, query("before.example.com", layers.DNSTypeAAAA)))}, nil
+		case 2:
+			return []captureHandle{newFakeHandle(io.EOF, newQueryPacket(t, query("after.example.com", layers.DNSTypeAAAA)))}, nil
+		default:
+			return nil, errors.New("no such device")
+		}
+	}
+	var names []string
+	err := captureFrom("", open, []func(telescreenLog){func(l telescreenLog) { names = append(names, l.(*QueryLog).QString) }})
+
+	if err == nil || !strings.Contains(err.Error(), "Gave up reconnecting") {
+		t.Errorf("captureFrom() = %v, want giving up", err)
+	}
+	if strings.Join(names, ",") != "before.example.com,after.example.com" {
+		t.Errorf("captured %v, want a query before and after reconnecting", names)
+	}
+}
+
+func TestCaptureEndsWithoutReconnect(t *testing.T) {
+	defer func(reconnect bool) { reconnectFlag = reconnect }(reconnectFlag)
+	reconnectFlag = false
+
+	opened := 0
+	open := func() ([]captureHandle, error) {
+		opened += 1
+		return []captureHandle{newFakeHandle(errors.New("device went down"))}, nil
+	}
+	err := captureFrom("", open, nil)
+	if err == nil || !strings.Contains(err.Error(), "device went down") {
+		t.Errorf("captureFrom() = %v, want the read error", err)
+	}
+	if opened != 1 {
+		t.Errorf("opened the device %d times, want once", opened)
+	}
+}
+
+func TestCaptureReopensWhenIdle(t *testing.T) {
+	defer func(idle time.Duration, reconnect bool) { idleTimeout, reconnectFlag = idle, reconnect }(idleTimeout, reconnectFlag)
+	idleTimeout, reconnectFlag = 50*time.Millisecond, false
+
+	// The first handle goes quiet after a query without failing, and is
+	// reopened even without --reconnect
+	opened := 0
+	open := func() ([]captureHandle, error) {
+		opened += 1
+		switch opened {
+		case 1:
+			handle := newFakeHandle(nil, newQueryPacket(t, query("before.example.com", layers.DNSTypeAAAA)))
+			handle.blocking = true
+			return []captureHandle{handle}, nil
+		default:
+			return []captureHandle{newFakeHandle(io.EOF, newQueryPacket(t, query("after.example.com", layers.DNSTypeAAAA)))}, nil
+		}
+	}
+	var names []string
+	if err := captureFrom("", open, []func(telescreenLog){func(l telescreenLog) { names = append(names, l.(*QueryLog).QString) }}); err != nil {
+		t.Errorf("captureFrom() = %v", err)
+	}
+	if strings.Join(names, ",") != "before.example.com,after.example.com" {
+		t.Errorf("captured %v, want a query before and after reopening", names)
+	}
+}
+
+func TestCaptureTinyIdleTimeout(t *testing.T) {
+	defer func(idle time.Duration) { idleTimeout = idle }(idleTimeout)
+	idleTimeout = time.Nanosecond
+
+	// Too short to be divided for a ticker, but no panic
+	if _, err := capture("", []captureHandle{newFakeHandle(io.EOF)}, nil); err != nil && !errors.Is(err, errIdle) {
+		t.Errorf("capture() = %v, want nil or errIdle", err)
+	}
+}
+
+func TestCaptureFileErrorWithReconnect(t *testing.T) {
+	defer func(reconnect bool, file string) { reconnectFlag, readFile = reconnect, file }(reconnectFlag, readFile)
+	reconnectFlag, readFile = true, "broken.pcap"
+
+	opened := 0
+	open := func() ([]captureHandle, error) {
+		opened += 1
+		return []captureHandle{newFakeHandle(errors.New("truncated dump file"))}, nil
+	}
+	err := captureFrom("", open, nil)
+	if err == nil || !strings.Contains(err.Error(), "truncated dump file") {
+		t.Errorf("captureFrom() = %v, want the read error", err)
+	}
+	if opened != 1 {
+		t.Errorf("opened the file %d times, want once", opened)
+	}
+}
+
+func TestCaptureStopsOnStopRun(t *testing.T) {
+	defer resetShutdown()
+	defer func(reconnect bool) { reconnectFlag = reconnect }(reconnectFlag)
+	reconnectFlag = true
+
+	handle := newFakeHandle(nil)
+	handle.blocking = true
+	open := func() ([]captureHandle, error) { return []captureHandle{handle}, nil }
+	done := make(chan error)
+	go func() { done <- captureFrom("", open, nil) }()
+
+	stopRun()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("captureFrom() = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("capture not stopped")
+	}
+}
+
+func TestCaptureTimeWindow(t *testing.T) {
+	defer func(s, u time.Time) { since, until = s, u }(since, until)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	since, until = start.Add(time.Minute), start.Add(2*time.Minute)
+
+	// A packet back in the window after one past it is not read, as nothing
+	// of interest follows
+	var packets []gopacket.Packet
+	for _, p := range []struct {
+		name string
+		ts   time.Time
+	}{
+		{"before.example.com", start},
+		{"first.example.com", since},
+		{"last.example.com", until},
+		{"after.example.com", until.Add(time.Second)},
+		{"late.example.com", since},
+	} {
+		packet := newQueryPacket(t, query(p.name, layers.DNSTypeAAAA))
+		packet.Metadata().Timestamp = p.ts
+		packets = append(packets, packet)
+	}
+
+	var names []string
+	_, err := capture("", []captureHandle{newFakeHandle(io.EOF, packets...)}, []func(telescreenLog){func(l telescreenLog) { names = append(names, l.(*QueryLog).QString) }})
+	if err != nil {
+		t.Fatalf("capture() = %v", err)
+	}
+	if strings.Join(names, ",") != "first.example.com,last.example.com" {
+		t.Errorf("captured %v, want the queries between --since and --until", names)
+	}
+}
+
+func TestSplitCapture(t *testing.T) {
+	defer func(capture bool) { captureFlag = capture }(captureFlag)
+	captureFlag = true
+
+	// The handle of responses is read ahead of that of queries
+	var queries, responses []gopacket.Packet
+	for i, name := range []string{"first.example.com", "second.example.com"} {
+		q := newQueryPacket(t, query(name, layers.DNSTypeAAAA))
+		q.Metadata().Timestamp = q.Metadata().Timestamp.Add(time.Duration(2*i) * time.Millisecond)
+		queries = append(queries, q)
+		r := newResponsePacket(t, response(name, layers.DNSTypeAAAA, aaaa(name, "2001:db8::80", 300)), time.Duration(2*i+1)*time.Millisecond)
+		responses = append(responses, r)
+	}
+	var got []string
+	_, err := capture("", []captureHandle{newFakeHandle(io.EOF, responses...), newFakeHandle(io.EOF, queries...)}, []func(telescreenLog){func(l telescreenLog) {
+		switch l := l.(type) {
+		case *QueryLog:
+			got = append(got, "query "+l.QString)
+		case *ResponseLog:
+			got = append(got, "response "+l.QString)
+		}
+	}})
+	if err != nil {
+		t.Fatalf("capture() = %v", err)
+	}
+	want := "query first.example.com,response first.example.com,query second.example.com,response second.example.com"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got %v, want both handles in order of time", got)
+	}
+}
+
+func TestCaptureHostAndInterface(t *testing.T) {
+	defer func(host, dev string, show bool) { captureHost, device, showCapture = host, dev, show }(captureHost, device, showCapture)
+	captureHost, device, showCapture = "sensor1", "eth0", true
+
+	// Tagged with the interface of the capture rather than --dev
+	open := func() ([]captureHandle, error) {
+		return []captureHandle{newFakeHandle(io.EOF, newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA)))}, nil
+	}
+	var logs []telescreenLog
+	if err := captureFrom("eth1", open, []func(telescreenLog){func(l telescreenLog) { logs = append(logs, l) }}); err != nil {
+		t.Fatalf("captureFrom() = %v", err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("captured %d logs, want 1", len(logs))
+	}
+	c := logCommon(logs[0])
+	if c.CaptureHost != "sensor1" || c.Interface != "eth1" {
+		t.Errorf("captured on %q at %q, want eth1 at sensor1", c.Interface, c.CaptureHost)
+	}
+	if s := logs[0].String(); !strings.Contains(s, " | sensor1%eth1 | ") {
+		t.Errorf("String() = %q, want the capture point", s)
+	}
+	if e := newDnsEvent(logs[0]); e.CaptureHost != "sensor1" || e.CaptureInterface != "eth1" {
+		t.Errorf("event captured on %q at %q, want eth1 at sensor1", e.CaptureInterface, e.CaptureHost)
+	}
+
+	// Nothing tells the interface of packets read from elsewhere
+	logs = interceptAll(newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA)))
+	if c := logCommon(logs[0]); c.Interface != "" {
+		t.Errorf("read on %q, want no interface", c.Interface)
+	}
+}
+
+func TestListInterfaces(t *testing.T) {
+	var b bytes.Buffer
+	listInterfaces(&b, nil)
+	if !strings.HasPrefix(b.String(), "No interfaces found") {
+		t.Errorf("listing without devices = %q", b.String())
+	}
+
+	b.Reset()
+	listInterfaces(&b, []pcap.Interface{{
+		Name:        "eth0",
+		Description: "Uplink",
+		Addresses:   []pcap.InterfaceAddress{{IP: net.ParseIP("192.0.2.1")}, {IP: net.ParseIP("2001:db8::1")}},
+	}})
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 || strings.Fields(lines[0])[0] != "NAME" || strings.Join(strings.Fields(lines[1]), " ") != "eth0 Uplink 192.0.2.1, 2001:db8::1" {
+		t.Errorf("listing = %q", b.String())
+	}
+}
+
+func TestEndpointsFollowDirection(t *testing.T) {
+	defer func(sniff bool) { sniffFlag = sniff }(sniffFlag)
+	sniffFlag = true
+
+	q, ok := parsePacket(newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA))).(*QueryLog)
+	if !ok {
+		t.Fatal("query not parsed")
+	}
+	r, ok := parsePacket(newResponsePacket(t, response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300)), 0)).(*ResponseLog)
+	if !ok {
+		t.Fatal("response not parsed")
+	}
+
+	// The response is sent from the server, but the client stays the client
+	if r.SrcIP.String() != testServer || r.SrcPort != 53 {
+		t.Errorf("response sent from %v.%d, want %s.53", r.SrcIP, r.SrcPort, testServer)
+	}
+	for _, c := range []telescreenLogCommon{q.telescreenLogCommon, r.telescreenLogCommon} {
+		if c.ClientIP.String() != testClient || c.ClientPort != 40000 || c.ServerIP.String() != testServer || c.ServerPort != 53 {
+			t.Errorf("client %v.%d and server %v.%d, want %s.40000 and %s.53", c.ClientIP, c.ClientPort, c.ServerIP, c.ServerPort, testClient, testServer)
+		}
+	}
+}
+
+func TestResponsesOnly(t *testing.T) {
+	defer func(only bool) { responsesOnly = only }(responsesOnly)
+	responsesOnly = true
+
+	logs := interceptAll(
+		newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA)),
+		newResponsePacket(t, response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300)), 0),
+		newQueryPacket(t, query("www.example.net", layers.DNSTypeAAAA)),
+		newResponsePacket(t, response("www.example.net", layers.DNSTypeAAAA), 0),
+	)
+	if len(logs) != 1 {
+		t.Fatalf("exported %d logs, want 1", len(logs))
+	}
+	if r, ok := logs[0].(*ResponseLog); !ok || r.QString != "www.example.com" {
+		t.Errorf("exported %v, want the response for www.example.com", logs[0])
+	}
+}
+
+func TestResponseWithoutQuery(t *testing.T) {
+	defer func(capture bool) { captureFlag = capture }(captureFlag)
+	captureFlag = true
+
+	// Neither query was captured
+	answered := response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300))
+	answered.ID = 0x1234
+	nxdomain := response("nx.example.com", layers.DNSTypeA)
+	nxdomain.ResponseCode = layers.DNSResponseCodeNXDomain
+	logs := interceptAll(newResponsePacket(t, answered, 0), newResponsePacket(t, nxdomain, 0))
+	if len(logs) != 2 {
+		t.Fatalf("exported %d logs, want both responses", len(logs))
+	}
+	tests := []struct {
+		name  string
+		qtype string
+		ip    string
+	}{
+		{"www.example.com", "AAAA", "2001:db8::80"},
+		{"nx.example.com", "A", "<nil>"},
+	}
+	for i, tt := range tests {
+		r, ok := logs[i].(*ResponseLog)
+		if !ok {
+			t.Fatalf("exported %T, want a response", logs[i])
+		}
+		if r.QString != tt.name || r.QType != tt.qtype || r.AnsIP.String() != tt.ip {
+			t.Errorf("got %s %s at %v, want %s %s at %s", r.QString, r.QType, r.AnsIP, tt.name, tt.qtype, tt.ip)
+		}
+		if r.ClientIP.String() != testClient || r.ServerIP.String() != testServer || r.SrcIP.String() != testServer {
+			t.Errorf("%s: got client %v and server %v from %v", tt.name, r.ClientIP, r.ServerIP, r.SrcIP)
+		}
+		if !strings.Contains(r.String(), tt.name) {
+			t.Errorf("%s: not rendered: %s", tt.name, r.String())
+		}
+	}
+	if r := logs[0].(*ResponseLog); r.TransID != 0x1234 || r.AnsTypes != "1xAAAA" || !r.IPv6Ready {
+		t.Errorf("got ID %#x, types %q and IPv6 ready %v", r.TransID, r.AnsTypes, r.IPv6Ready)
+	}
+}
+
+func TestAnswerCIDR(t *testing.T) {
+	defer func(only bool, nets []*net.IPNet) { responsesOnly, answerNets = only, nets }(responsesOnly, answerNets)
+	_, n, _ := net.ParseCIDR("2001:db8:1::/64")
+	responsesOnly, answerNets = true, []*net.IPNet{n}
+
+	logs := interceptAll(
+		newResponsePacket(t, response("inside.example.com", layers.DNSTypeAAAA, aaaa("inside.example.com", "2001:db8:1::80", 300)), 0),
+		newResponsePacket(t, response("outside.example.com", layers.DNSTypeAAAA, aaaa("outside.example.com", "2001:db8:1:1::80", 300)), 0),
+	)
+	if len(logs) != 1 {
+		t.Fatalf("exported %d logs, want 1", len(logs))
+	}
+	if r, ok := logs[0].(*ResponseLog); !ok || r.QString != "inside.example.com" {
+		t.Errorf("exported %v, want the response answering inside the /64", logs[0])
+	}
+}
+
+func TestRcodeFilter(t *testing.T) {
+	defer func(capture bool, rcodes []layers.DNSResponseCode) { captureFlag, rcodeFilter = capture, rcodes }(captureFlag, rcodeFilter)
+	captureFlag, rcodeFilter = true, []layers.DNSResponseCode{layers.DNSResponseCodeNXDomain}
+
+	nx := response("nx.example.com", layers.DNSTypeAAAA)
+	nx.ResponseCode = layers.DNSResponseCodeNXDomain
+	servfail := response("fail.example.com", layers.DNSTypeAAAA)
+	servfail.ResponseCode = layers.DNSResponseCodeServFail
+	dropped := droppedFor(dropRcode)
+	logs := interceptAll(
+		newResponsePacket(t, response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300)), 0),
+		newResponsePacket(t, nx, 0),
+		newResponsePacket(t, servfail, 0),
+	)
+	if len(logs) != 1 {
+		t.Fatalf("exported %d logs, want 1", len(logs))
+	}
+	if r, ok := logs[0].(*ResponseLog); !ok || r.QString != "nx.example.com" {
+		t.Errorf("exported %v, want the NXDOMAIN response", logs[0])
+	}
+	if got := droppedFor(dropRcode) - dropped; got != 2 {
+		t.Errorf("counted %d responses dropped by --rcode, want 2", got)
+	}
+}
+
+func TestResponseToggles(t *testing.T) {
+	defer func(sniff, only, capture, empty bool, types []string) {
+		sniffFlag, responsesOnly, captureFlag, dropEmpty, responseTypes = sniff, only, capture, empty, types
+	}(sniffFlag, responsesOnly, captureFlag, dropEmpty, responseTypes)
+
+	nxdomain := response("nx.example.com", layers.DNSTypeAAAA)
+	nxdomain.ResponseCode = layers.DNSResponseCodeNXDomain
+	packets := []gopacket.Packet{
+		newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA)),
+		newResponsePacket(t, response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300)), 0),
+		newResponsePacket(t, nxdomain, 0),
+		newResponsePacket(t, response("v4.example.com", layers.DNSTypeA, a("v4.example.com", "192.0.2.1")), 0),
+	}
+
+	tests := []struct {
+		name                        string
+		sniff, only, capture, empty bool
+		types                       []string
+		want                        string
+	}{
+		{"queries only", false, false, false, false, nil, "Q www"},
+		{"-A", true, false, false, false, nil, "Q www,R www"},
+		{"--responses-only", false, true, false, false, nil, "R www"},
+		{"--capture-responses", false, false, true, false, nil, "Q www,R www,R nx,R v4"},
+		{"--capture-responses --drop-empty-response", false, false, true, true, nil, "Q www,R www,R v4"},
+		{"--response-types A", false, false, false, false, []string{"A"}, "Q www,R v4"},
+		{"--response-types aaaa", false, false, false, false, []string{"aaaa"}, "Q www,R www,R nx"},
+		{"--response-types AAAA --drop-empty-response", false, false, false, true, []string{"AAAA"}, "Q www,R www"},
+		{"--responses-only --capture-responses", false, true, true, false, nil, "R www,R nx,R v4"},
+		{"-A --capture-responses", true, false, true, false, nil, "Q www,R www,R nx,R v4"},
+	}
+	for _, tt := range tests {
+		sniffFlag, responsesOnly, captureFlag, dropEmpty, responseTypes = tt.sniff, tt.only, tt.capture, tt.empty, tt.types
+		var got []string
+		for _, l := range interceptAll(packets...) {
+			switch l := l.(type) {
+			case *ResponseLog:
+				got = append(got, "R "+strings.Split(l.QString, ".")[0])
+			case *QueryLog:
+				got = append(got, "Q "+strings.Split(l.QString, ".")[0])
+			}
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: exported %v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCountLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		want int
+	}{
+		{"", 0},
+		{".", 0},
+		{"com", 1},
+		{"www.example.com", 3},
+		{"www.example.com.", 3},
+	}
+	for _, tt := range tests {
+		if got := countLabels(tt.name); got != tt.want {
+			t.Errorf("countLabels(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLabelFilter(t *testing.T) {
+	defer func(sniff bool, min, max int) { sniffFlag, minLabels, maxLabels = sniff, min, max }(sniffFlag, minLabels, maxLabels)
+	sniffFlag, minLabels, maxLabels = true, 2, 3
+
+	var packets []gopacket.Packet
+	for _, name := range []string{"com", "example.com", "www.example.com", "a.www.example.com"} {
+		packets = append(packets,
+			newQueryPacket(t, query(name, layers.DNSTypeAAAA)),
+			newResponsePacket(t, response(name, layers.DNSTypeAAAA, aaaa(name, "2001:db8::80", 300)), 0),
+		)
+	}
+	var names []string
+	for _, l := range interceptAll(packets...) {
+		switch l := l.(type) {
+		case *ResponseLog:
+			names = append(names, l.QString+" response")
+		case *QueryLog:
+			names = append(names, l.QString)
+		}
+	}
+	if want := "example.com,example.com response,www.example.com,www.example.com response"; strings.Join(names, ",") != want {
+		t.Errorf("exported %v, want %s", names, want)
+	}
+}
+
+func TestDecodeReverseName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"1.0.0.127.in-addr.arpa", "127.0.0.1"},
+		{"1.0.0.127.IN-ADDR.ARPA.", "127.0.0.1"},
+		{"b.a.9.8.7.6.5.0.4.0.0.0.3.0.0.0.2.0.0.0.1.0.0.0.0.0.0.0.1.2.3.4.ip6.arpa", "4321:0:1:2:3:4:567:89ab"},
+		{"0.168.192.in-addr.arpa", ""},
+		{"1.0.0.256.in-addr.arpa", ""},
+		{"x.0.0.1.in-addr.arpa", ""},
+		{"0.0.8.b.d.0.1.0.0.2.ip6.arpa", ""},
+		{"www.example.com", ""},
+	}
+	for _, tt := range tests {
+		got := decodeReverseName(tt.name)
+		if tt.want == "" {
+			if got != nil {
+				t.Errorf("decodeReverseName(%q) = %v, want nil", tt.name, got)
+			}
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("decodeReverseName(%q) = %v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPTRQueryAddress(t *testing.T) {
+	q, ok := parsePacket(newQueryPacket(t, query("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa", layers.DNSTypePTR))).(*QueryLog)
+	if !ok {
+		t.Fatal("PTR query not parsed")
+	}
+	if q.PTRAddr.String() != "2001:db8::1" || !strings.Contains(q.String(), "(2001:db8::1)") {
+		t.Errorf("PTR query for %v rendered %q, want 2001:db8::1", q.PTRAddr, q.String())
+	}
+}
+
+func TestTimeFormat(t *testing.T) {
+	defer func(format string) { timeFormat = format }(timeFormat)
+	at := time.Date(2024, 1, 1, 0, 0, 0, 1500, time.UTC)
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"rfc3339", "2024-01-01T00:00:00Z"},
+		{"rfc3339nano", "2024-01-01T00:00:00.000001500Z"},
+		{"unix", "1704067200"},
+		{"unixnano", "1704067200000001500"},
+		{"15:04:05.000000", "00:00:00.000001"},
+	}
+	for _, tt := range tests {
+		timeFormat = tt.format
+		q := &QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.com", QType: "AAAA"}
+		q.Timestamp = at
+		if got := q.String(); !strings.HasPrefix(got, tt.want+" | ") {
+			t.Errorf("--time-format %s: got %q, want it to begin with %s", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestNameRegexes(t *testing.T) {
+	defer func(include, exclude []*regexp.Regexp) { includeNames, excludeNames = include, exclude }(includeNames, excludeNames)
+	var err error
+	if includeNames, err = compileNames([]string{`\.example\.com$`}); err != nil {
+		t.Fatal(err)
+	}
+	// Long random-looking labels of DNS tunneling
+	if excludeNames, err = compileNames([]string{`^[a-z0-9]{32,}\.`}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"www.example.com", true},
+		{"WWW.EXAMPLE.COM", true},
+		{"mfrggzdfmztwq2lknnwg23tpobyxe43uov3ho6dzpj2gc3td.example.com", false},
+		{"0123456789abcdef0123456789abcdef.example.com", false},
+		{"0123456789abcdef.example.com", true},
+		{"www.example.net", false},
+	}
+	for _, tt := range tests {
+		if got := selectsName(tt.name); got != tt.want {
+			t.Errorf("selectsName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	logs := interceptAll(
+		newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA)),
+		newQueryPacket(t, query("mfrggzdfmztwq2lknnwg23tpobyxe43uov3ho6dzpj2gc3td.example.com", layers.DNSTypeTXT)),
+		newQueryPacket(t, query("www.example.net", layers.DNSTypeAAAA)),
+	)
+	if got, want := queryNames(logs), "www.example.com"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCompileNamesInvalid(t *testing.T) {
+	if _, err := compileNames([]string{`example\.com`, `(unclosed`}); err == nil {
+		t.Error("compiled an invalid regular expression")
+	}
+}
+
+func TestWriteRunErrorGuidesPrivileges(t *testing.T) {
+	denied := fmt.Errorf("Failed to start capturing: %w", errors.New("eth0: You don't have permission to capture on that device (socket: Operation not permitted)"))
+	tests := []struct {
+		goos string
+		want string
+	}{
+		{"linux", "sudo setcap cap_net_raw,cap_net_admin=eip /usr/bin/telescreen"},
+		{"darwin", "ChmodBPF"},
+		{"freebsd", "/dev/bpf*"},
+		{"windows", "Npcap"},
+		{"plan9", "run as root or administrator"},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		writeRunError(&b, denied, tt.goos, "/usr/bin/telescreen")
+		out := b.String()
+		if !strings.HasPrefix(out, denied.Error()+"\n") {
+			t.Errorf("%s: the error not written first: %q", tt.goos, out)
+		}
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%s: guidance %q, want %q in it", tt.goos, out, tt.want)
+		}
+		if tt.goos != "linux" && strings.Contains(out, "setcap") {
+			t.Errorf("%s: guided to setcap of Linux: %q", tt.goos, out)
+		}
+	}
+
+	var b bytes.Buffer
+	writeRunError(&b, errors.New("Failed to set BPF filter: syntax error"), "linux", "telescreen")
+	if strings.Contains(b.String(), "Capturing requires") {
+		t.Errorf("guidance for an error other than permission: %q", b.String())
+	}
+}
+
+func TestFQDNStyle(t *testing.T) {
+	defer func(style string) { fqdnStyle = style }(fqdnStyle)
+	tests := []struct {
+		style string
+		name  string
+		want  string
+	}{
+		{"strip", "www.example.com", "www.example.com"},
+		{"strip", "www.example.com.", "www.example.com"},
+		{"keep", "www.example.com", "www.example.com."},
+		{"keep", "www.example.com.", "www.example.com."},
+		{"strip", "", ""},
+		{"keep", "", "."},
+	}
+	for _, tt := range tests {
+		fqdnStyle = tt.style
+		if got := canonicalName(tt.name); got != tt.want {
+			t.Errorf("--fqdn-style %s: canonicalName(%q) = %q, want %q", tt.style, tt.name, got, tt.want)
+		}
+	}
+
+	// Queries and responses alike
+	defer func(capture bool) { captureFlag = capture }(captureFlag)
+	captureFlag, fqdnStyle = true, "keep"
+	logs := interceptAll(
+		newQueryPacket(t, query("www.example.com", layers.DNSTypeAAAA)),
+		newResponsePacket(t, response("www.example.com", layers.DNSTypeAAAA, aaaa("www.example.com", "2001:db8::80", 300)), 0),
+	)
+	if len(logs) != 2 {
+		t.Fatalf("got %d logs, want 2", len(logs))
+	}
+	for _, l := range logs {
+		var name string
+		switch l := l.(type) {
+		case *QueryLog:
+			name = l.QString
+		case *ResponseLog:
+			name = l.QString
+		}
+		if name != "www.example.com." {
+			t.Errorf("logged %T for %q, want www.example.com.", l, name)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// sourcedPacket is a packet from one of the sources merged, or nil once the
+// source ends.
+type sourcedPacket struct {
+	source int
+	packet gopacket.Packet
+}
+
+// mergeByTime passes the packets from the sources on in order of time until
+// all of them end, then closes out. Each source is in order by itself, so the
+// earliest of the first packets held from each goes first once every source
+// has one. A quiet source holds up the others for hold at most, e.g., one
+// capturing responses while nobody gets any, and 0 means until it ends.
+func mergeByTime(sources []<-chan gopacket.Packet, out chan<- gopacket.Packet, hold time.Duration) {
+	defer close(out)
+
+	in := make(chan sourcedPacket)
+	for i, source := range sources {
+		go func(i int, source <-chan gopacket.Packet) {
+			for packet := range source {
+				in <- sourcedPacket{i, packet}
+			}
+			in <- sourcedPacket{i, nil}
+		}(i, source)
+	}
+
+	held := make([][]gopacket.Packet, len(sources))
+	ended := make([]bool, len(sources))
+	open := len(sources)
+	var expired <-chan time.Time
+	var timer *time.Timer
+	flush := false
+	for {
+		for {
+			earliest, waiting := -1, false
+			for i, packets := range held {
+				if len(packets) == 0 {
+					waiting = waiting || !ended[i]
+					continue
+				}
+				if earliest < 0 || packets[0].Metadata().Timestamp.Before(held[earliest][0].Metadata().Timestamp) {
+					earliest = i
+				}
+			}
+			if earliest < 0 || (waiting && !flush) {
+				break
+			}
+			out <- held[earliest][0]
+			held[earliest] = held[earliest][1:]
+		}
+		flush = false
+		if open == 0 {
+			return
+		}
+
+		// Waiting for a quiet source starts once a packet is held up by it
+		pending := false
+		for _, packets := range held {
+			pending = pending || len(packets) > 0
+		}
+		switch {
+		case !pending && timer != nil:
+			timer.Stop()
+			timer, expired = nil, nil
+		case pending && timer == nil && hold > 0:
+			timer = time.NewTimer(hold)
+			expired = timer.C
+		}
+
+		select {
+		case p := <-in:
+			if p.packet == nil {
+				ended[p.source] = true
+				open -= 1
+				continue
+			}
+			held[p.source] = append(held[p.source], p.packet)
+		case <-expired:
+			timer, expired = nil, nil
+			flush = true
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// mergeTestPacket is a query captured at the offset from the start.
+func mergeTestPacket(t *testing.T, name string, offset time.Duration) gopacket.Packet {
+	t.Helper()
+	packet := newQueryPacket(t, query(name, layers.DNSTypeAAAA))
+	packet.Metadata().Timestamp = packet.Metadata().Timestamp.Add(offset)
+	return packet
+}
+
+func TestMergeByTime(t *testing.T) {
+	a, b := make(chan gopacket.Packet, 3), make(chan gopacket.Packet, 3)
+	a <- mergeTestPacket(t, "a0.example.com", 0)
+	a <- mergeTestPacket(t, "a3.example.com", 3*time.Millisecond)
+	a <- mergeTestPacket(t, "a4.example.com", 4*time.Millisecond)
+	close(a)
+	b <- mergeTestPacket(t, "b1.example.com", time.Millisecond)
+	b <- mergeTestPacket(t, "b2.example.com", 2*time.Millisecond)
+	b <- mergeTestPacket(t, "b5.example.com", 5*time.Millisecond)
+	close(b)
+
+	out := make(chan gopacket.Packet)
+	go mergeByTime([]<-chan gopacket.Packet{a, b}, out, 0)
+	var got []telescreenLog
+	for packet := range out {
+		got = append(got, parsePacket(packet))
+	}
+	want := "a0.example.com,b1.example.com,b2.example.com,a3.example.com,a4.example.com,b5.example.com"
+	if names := queryNames(got); names != want {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
+
+func TestMergeByTimeQuietSource(t *testing.T) {
+	a, quiet := make(chan gopacket.Packet, 1), make(chan gopacket.Packet)
+	a <- mergeTestPacket(t, "www.example.com", 0)
+
+	// The packet is held up for a while, but not until the quiet one ends
+	out := make(chan gopacket.Packet)
+	go mergeByTime([]<-chan gopacket.Packet{a, quiet}, out, 10*time.Millisecond)
+	select {
+	case packet := <-out:
+		if name := queryNames([]telescreenLog{parsePacket(packet)}); name != "www.example.com" {
+			t.Errorf("got %s, want www.example.com", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("held up by the quiet source")
+	}
+
+	close(a)
+	close(quiet)
+	if _, ok := <-out; ok {
+		t.Error("output not closed after the sources ended")
+	}
+}
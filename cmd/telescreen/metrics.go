@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const queryTimeout time.Duration = 5 * time.Second // Queries unanswered for this long are given up
+
+// Upper bounds of the latency buckets, the last one is 1s+
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+type latencyHistogram struct {
+	counts []uint64 // Per bucket, not cumulative, the last one for 1s+
+	sum    time.Duration
+	total  uint64
+}
+
+// latencyMetrics pairs responses with the queries they answer and keeps a
+// histogram of the resolution latencies per query type. Only the counts are
+// kept, not the logs themselves. Queries pushed out of a full table are not
+// counted as unanswered, but as evictions of the cache.
+type latencyMetrics struct {
+	mu         sync.Mutex
+	pending    *boundedCache // Send times of queries waiting for a response
+	histograms map[string]*latencyHistogram
+	unanswered uint64
+	lastSweep  time.Time
+}
+
+func newLatencyMetrics(size int) *latencyMetrics {
+	m := &latencyMetrics{
+		pending:    newBoundedCache("latency_pending", size, queryTimeout),
+		histograms: map[string]*latencyHistogram{},
+	}
+	m.pending.onEvict = func(key string, value interface{}, expired bool) {
+		if expired {
+			m.unanswered += 1
+		}
+	}
+	return m
+}
+
+// observe takes a query or a response, recording the latency when the latter
+// answers a query seen before.
+func (m *latencyMetrics) observe(q *QueryLog) {
+	key := correlationKey(q)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(q.Timestamp)
+	if !q.isResponse {
+		m.pending.put(key, q.Timestamp, q.Timestamp)
+		return
+	}
+
+	value, ok := m.pending.get(key, q.Timestamp)
+	if !ok {
+		return
+	}
+	m.pending.remove(key)
+	sent := value.(time.Time)
+
+	h, ok := m.histograms[q.QType]
+	if !ok {
+		h = &latencyHistogram{counts: make([]uint64, len(latencyBuckets)+1)}
+		m.histograms[q.QType] = h
+	}
+	latency := q.Timestamp.Sub(sent)
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return latency <= latencyBuckets[i] })
+	h.counts[i] += 1
+	h.sum += latency
+	h.total += 1
+}
+
+// sweep counts the queries pending for too long as unanswered, once a second.
+func (m *latencyMetrics) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Second {
+		return
+	}
+	m.lastSweep = now
+	m.pending.expire(now)
+}
+
+func (m *latencyMetrics) qtypes() []string {
+	qtypes := make([]string, 0, len(m.histograms))
+	for qtype := range m.histograms {
+		qtypes = append(qtypes, qtype)
+	}
+	sort.Strings(qtypes)
+	return qtypes
+}
+
+// writePrometheus writes the metrics in the Prometheus text format.
+func (m *latencyMetrics) writePrometheus(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP telescreen_resolution_latency_seconds Time from a query to its response.")
+	fmt.Fprintln(w, "# TYPE telescreen_resolution_latency_seconds histogram")
+	for _, qtype := range m.qtypes() {
+		h := m.histograms[qtype]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "telescreen_resolution_latency_seconds_bucket{qtype=%q,le=\"%g\"} %d\n", qtype, bound.Seconds(), cumulative)
+		}
+		fmt.Fprintf(w, "telescreen_resolution_latency_seconds_bucket{qtype=%q,le=\"+Inf\"} %d\n", qtype, h.total)
+		fmt.Fprintf(w, "telescreen_resolution_latency_seconds_sum{qtype=%q} %g\n", qtype, h.sum.Seconds())
+		fmt.Fprintf(w, "telescreen_resolution_latency_seconds_count{qtype=%q} %d\n", qtype, h.total)
+	}
+	fmt.Fprintln(w, "# HELP telescreen_unanswered_queries_total Queries without a response in time.")
+	fmt.Fprintln(w, "# TYPE telescreen_unanswered_queries_total counter")
+	fmt.Fprintf(w, "telescreen_unanswered_queries_total %d\n", m.unanswered)
+	if counts := dropCounts(); len(counts) > 0 {
+		fmt.Fprintln(w, "# HELP telescreen_dropped_packets_total Packets captured but producing no log, by the reason.")
+		fmt.Fprintln(w, "# TYPE telescreen_dropped_packets_total counter")
+		for _, c := range counts {
+			fmt.Fprintf(w, "telescreen_dropped_packets_total{reason=%q} %d\n", c.reason, c.count)
+		}
+	}
+	if stats := allCacheStats(); len(stats) > 0 {
+		fmt.Fprintln(w, "# HELP telescreen_cache_entries Entries held by an in-memory cache.")
+		fmt.Fprintln(w, "# TYPE telescreen_cache_entries gauge")
+		for _, s := range stats {
+			fmt.Fprintf(w, "telescreen_cache_entries{cache=%q} %d\n", s.name, s.entries)
+		}
+		fmt.Fprintln(w, "# HELP telescreen_cache_evictions_total Entries removed from an in-memory cache, to make room or by the TTL.")
+		fmt.Fprintln(w, "# TYPE telescreen_cache_evictions_total counter")
+		for _, s := range stats {
+			fmt.Fprintf(w, "telescreen_cache_evictions_total{cache=%q,reason=\"size\"} %d\n", s.name, s.evictions)
+			fmt.Fprintf(w, "telescreen_cache_evictions_total{cache=%q,reason=\"ttl\"} %d\n", s.name, s.expirations)
+		}
+	}
+	if stats := allExportQueueStats(); len(stats) > 0 {
+		fmt.Fprintln(w, "# HELP telescreen_export_dropped_logs_total Logs dropped by the queue of an exporter that cannot keep up, under --overflow-policy.")
+		fmt.Fprintln(w, "# TYPE telescreen_export_dropped_logs_total counter")
+		for _, s := range stats {
+			fmt.Fprintf(w, "telescreen_export_dropped_logs_total{exporter=%q,policy=%q} %d\n", s.name, s.policy, s.dropped)
+		}
+	}
+	if limiter != nil {
+		fmt.Fprintln(w, "# HELP telescreen_rate_limited_logs_total Logs dropped over --max-rate.")
+		fmt.Fprintln(w, "# TYPE telescreen_rate_limited_logs_total counter")
+		fmt.Fprintf(w, "telescreen_rate_limited_logs_total %d\n", limiter.droppedLogs())
+	}
+}
+
+// writeSummary writes the histograms in a table, e.g., on exit.
+func (m *latencyMetrics) writeSummary(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "%-8s", "QTYPE")
+	for _, bound := range latencyBuckets {
+		fmt.Fprintf(w, " %8s", "<="+bound.String())
+	}
+	fmt.Fprintf(w, " %8s %8s\n", "1s+", "TOTAL")
+	for _, qtype := range m.qtypes() {
+		h := m.histograms[qtype]
+		fmt.Fprintf(w, "%-8s", qtype)
+		for _, count := range h.counts {
+			fmt.Fprintf(w, " %8d", count)
+		}
+		fmt.Fprintf(w, " %8d\n", h.total)
+	}
+	fmt.Fprintf(w, "Unanswered queries: %d\n", m.unanswered)
+}
+
+// serveMetrics serves the metrics at /metrics on the address. The returned
+// function stops the server and prints the summary.
+func serveMetrics(m *latencyMetrics, addr string) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.writePrometheus(w)
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+
+	closer := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+		m.writeSummary(os.Stderr)
+	}
+	return closer, nil
+}
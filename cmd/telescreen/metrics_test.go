@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	m := newLatencyMetrics(maxTTLCacheEntries)
+	observe := func(name string, qtype layers.DNSType, sport uint16, latency time.Duration) {
+		q := newDNSPacket(t, nil, testClient, testServer, sport, 53, query(name, qtype))
+		r := newDNSPacket(t, nil, testServer, testClient, 53, sport, response(name, qtype))
+		r.Metadata().Timestamp = q.Metadata().Timestamp.Add(latency)
+		m.observe(newQueryLog(q, newTelescreenLogCommon(q)))
+		m.observe(newQueryLog(r, newTelescreenLogCommon(r)))
+	}
+	observe("www.example.com", layers.DNSTypeAAAA, 40000, 500*time.Microsecond)
+	observe("www.example.net", layers.DNSTypeAAAA, 40001, 3*time.Millisecond)
+	observe("www.example.org", layers.DNSTypeAAAA, 40002, 5*time.Millisecond)
+	observe("slow.example.com", layers.DNSTypeAAAA, 40003, 2*time.Second)
+	observe("www.example.com", layers.DNSTypeA, 40004, 20*time.Millisecond)
+
+	// Per bucket up to 1ms, 5ms, 10ms, 50ms, 100ms, 500ms, 1s and then 1s+
+	want := map[string][]uint64{
+		"AAAA": {1, 2, 0, 0, 0, 0, 0, 1},
+		"A":    {0, 0, 0, 1, 0, 0, 0, 0},
+	}
+	for qtype, counts := range want {
+		h, ok := m.histograms[qtype]
+		if !ok {
+			t.Errorf("no histogram for %s", qtype)
+			continue
+		}
+		if !reflect.DeepEqual(h.counts, counts) || h.total != sum(counts) {
+			t.Errorf("%s counts %v of %d, want %v", qtype, h.counts, h.total, counts)
+		}
+	}
+
+	var b bytes.Buffer
+	m.writePrometheus(&b)
+	for _, line := range []string{
+		`telescreen_resolution_latency_seconds_bucket{qtype="AAAA",le="0.001"} 1`,
+		`telescreen_resolution_latency_seconds_bucket{qtype="AAAA",le="0.005"} 3`,
+		`telescreen_resolution_latency_seconds_bucket{qtype="AAAA",le="1"} 3`,
+		`telescreen_resolution_latency_seconds_bucket{qtype="AAAA",le="+Inf"} 4`,
+		`telescreen_resolution_latency_seconds_count{qtype="AAAA"} 4`,
+		`telescreen_resolution_latency_seconds_bucket{qtype="A",le="0.01"} 0`,
+		`telescreen_resolution_latency_seconds_bucket{qtype="A",le="0.05"} 1`,
+		`telescreen_unanswered_queries_total 0`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("metrics lack %s:\n%s", line, b.String())
+		}
+	}
+}
+
+func sum(counts []uint64) uint64 {
+	var n uint64
+	for _, c := range counts {
+		n += c
+	}
+	return n
+}
+
+func TestLatencyUnanswered(t *testing.T) {
+	m := newLatencyMetrics(maxTTLCacheEntries)
+	packet := newQueryPacket(t, query("lost.example.com", layers.DNSTypeAAAA))
+	q := newQueryLog(packet, newTelescreenLogCommon(packet))
+	m.observe(q)
+
+	// Swept by whatever comes after the timeout
+	later := *q
+	later.QString = "www.example.com"
+	later.Timestamp = q.Timestamp.Add(queryTimeout + time.Second)
+	m.observe(&later)
+	if m.unanswered != 1 || m.pending.len() != 1 {
+		t.Errorf("%d unanswered and %d pending, want 1 and 1", m.unanswered, m.pending.len())
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const parquetRowGroupSize int64 = 8 * 1024 * 1024 // Rows are flushed to the file in groups of this size
+
+// parquetRow is a log flattened into the columns of a Parquet file, which are
+// named after those of the database. Every kind of log shares the schema, so
+// fields not applicable to the kind are left empty.
+type parquetRow struct {
+	Kind          string `parquet:"name=kind, type=BYTE_ARRAY, convertedtype=UTF8"` // query, response, dot or combined
+	ReceivedAt    int64  `parquet:"name=received_at, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	SrcIP         string `parquet:"name=src_ip, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DstIP         string `parquet:"name=dst_ip, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SrcPort       int32  `parquet:"name=src_port, type=INT32, convertedtype=UINT_16"`
+	DstPort       int32  `parquet:"name=dst_port, type=INT32, convertedtype=UINT_16"`
+	TCPTransport  bool   `parquet:"name=tcp_transport, type=BOOLEAN"`
+	Transport     string `parquet:"name=transport, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ClientIP      string `parquet:"name=client_ip, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ClientPort    int32  `parquet:"name=client_port, type=INT32, convertedtype=UINT_16"`
+	ServerIP      string `parquet:"name=server_ip, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ServerPort    int32  `parquet:"name=server_port, type=INT32, convertedtype=UINT_16"`
+	SourceHost    string `parquet:"name=source_host, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CaptureHost   string `parquet:"name=capture_host, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Interface     string `parquet:"name=capture_interface, type=BYTE_ARRAY, convertedtype=UTF8"`
+	QueryString   string `parquet:"name=query_string, type=BYTE_ARRAY, convertedtype=UTF8"`
+	QueryType     string `parquet:"name=query_type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PTRAddress    string `parquet:"name=ptr_address, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TransactionID int32  `parquet:"name=transaction_id, type=INT32, convertedtype=UINT_16"`
+	Opcode        string `parquet:"name=opcode, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AnswerIP      string `parquet:"name=answer_ip, type=BYTE_ARRAY, convertedtype=UTF8"`
+	IPv6Ready     bool   `parquet:"name=ipv6_ready, type=BOOLEAN"`
+	LikelyCached  bool   `parquet:"name=likely_cached, type=BOOLEAN"`
+	NewAnswer     bool   `parquet:"name=new_answer, type=BOOLEAN"`
+	Mismatch      bool   `parquet:"name=mismatch, type=BOOLEAN"`
+	RefAnswer     string `parquet:"name=ref_answer, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AnswerTypes   string `parquet:"name=answer_types, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AnswerSection string `parquet:"name=answer_section, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ServerID      string `parquet:"name=server_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CNAMEHops     int32  `parquet:"name=cname_hops, type=INT32, convertedtype=UINT_16"`
+	ExtErrors     string `parquet:"name=extended_errors, type=BYTE_ARRAY, convertedtype=UTF8"` // JSON as in the database
+	SNI           string `parquet:"name=sni, type=BYTE_ARRAY, convertedtype=UTF8"`
+	LatencyUs     int64  `parquet:"name=latency_us, type=INT64"`
+	Timeout       bool   `parquet:"name=timeout, type=BOOLEAN"`
+
+	Fields map[string]string `parquet:"name=fields, type=MAP, convertedtype=MAP, keytype=BYTE_ARRAY, keyconvertedtype=UTF8, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"` // Added by enrichers
+}
+
+// ipString leaves a missing address empty rather than "<nil>".
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// newParquetRow returns nil for logs other than those defined here.
+func newParquetRow(qr telescreenLog) *parquetRow {
+	c := logCommon(qr)
+	if c == nil {
+		return nil
+	}
+
+	row := &parquetRow{
+		ReceivedAt:   c.Timestamp.UnixNano() / 1000,
+		SrcIP:        ipString(c.SrcIP),
+		DstIP:        ipString(c.DstIP),
+		SrcPort:      int32(c.SrcPort),
+		DstPort:      int32(c.DstPort),
+		TCPTransport: c.TransTCP,
+		Transport:    c.Transport,
+		ClientIP:     ipString(c.ClientIP),
+		ClientPort:   int32(c.ClientPort),
+		ServerIP:     ipString(c.ServerIP),
+		ServerPort:   int32(c.ServerPort),
+		SourceHost:   c.SourceHost,
+		CaptureHost:  c.CaptureHost,
+		Interface:    c.Interface,
+		Fields:       c.Fields,
+	}
+
+	var q *QueryLog
+	var log *ResponseLog
+	switch l := qr.(type) {
+	case *QueryLog:
+		row.Kind = "query"
+		q = l
+	case *ResponseLog:
+		row.Kind = "response"
+		log = l
+	case *CombinedLog:
+		row.Kind = "combined"
+		log = &l.ResponseLog
+		row.LatencyUs = l.LatencyUs
+		row.Timeout = l.Timeout
+	case *DoTLog:
+		row.Kind = "dot"
+		row.SNI = l.SNI
+		return row
+	}
+	if log != nil {
+		q = &log.QueryLog
+		row.AnswerIP = ipString(log.AnsIP)
+		row.IPv6Ready = log.IPv6Ready
+		row.LikelyCached = log.LikelyCached
+		row.NewAnswer = log.NewAnswer
+		row.Mismatch = log.Mismatch
+		row.RefAnswer = log.RefAnswer
+		row.AnswerTypes = log.AnsTypes
+		row.AnswerSection = log.AnsSection
+		row.ServerID = log.ServerID
+		row.CNAMEHops = int32(log.CNAMEHops)
+		if log.ExtErrors != nil {
+			b, _ := json.Marshal(log.ExtErrors)
+			row.ExtErrors = string(b)
+		}
+	}
+	row.QueryString = q.QString
+	row.QueryType = q.QType
+	row.PTRAddress = ipString(q.PTRAddr)
+	row.TransactionID = int32(q.TransID)
+	row.Opcode = q.Opcode
+	return row
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// parquetOutput is the file being written.
+type parquetOutput struct {
+	f    *os.File
+	cw   *countingWriter
+	pw   *writer.ParquetWriter
+	rows int
+}
+
+// newParquetExporter writes logs to Parquet files named after the path with a
+// sequence number, e.g., logs-0001.parquet for logs.parquet. It rolls to the
+// next file once the current one reaches either limit, 0 meaning no limit. The
+// size is checked whenever a group of rows is flushed, so files may exceed it
+// by a row group.
+func newParquetExporter(path string, maxRows int, maxBytes int64) (func(qr telescreenLog), func(), error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	seq := 0
+
+	// Numbers already taken by earlier runs are skipped, never overwritten
+	open := func() (*parquetOutput, error) {
+		for {
+			seq += 1
+			f, err := os.OpenFile(fmt.Sprintf("%s-%04d%s", base, seq, ext), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			if os.IsExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			cw := &countingWriter{w: f}
+			pw, err := writer.NewParquetWriterFromWriter(cw, new(parquetRow), 1)
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+			pw.RowGroupSize = parquetRowGroupSize
+			return &parquetOutput{f: f, cw: cw, pw: pw}, nil
+		}
+	}
+
+	// Writing the footer makes the file readable
+	finish := func(file *parquetOutput) error {
+		err := file.pw.WriteStop()
+		if e := file.f.Close(); err == nil {
+			err = e
+		}
+		return err
+	}
+
+	file, err := open()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var mu sync.Mutex
+	exporter := func(qr telescreenLog) {
+		row := newParquetRow(qr)
+		if row == nil {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if file == nil {
+			return
+		}
+		if err := file.pw.Write(row); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write Parquet file: %v\n", err)
+			return
+		}
+		file.rows += 1
+
+		full := (maxRows > 0 && file.rows >= maxRows) || (maxBytes > 0 && file.cw.n >= maxBytes)
+		if !full {
+			return
+		}
+		if err := finish(file); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write Parquet file: %v\n", err)
+		}
+		if file, err = open(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open Parquet file: %v\n", err)
+		}
+	}
+
+	closer := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if file == nil {
+			return
+		}
+		if err := finish(file); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write Parquet file: %v\n", err)
+		}
+		file = nil
+	}
+
+	return exporter, closer, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// readParquetRows reads back all the rows of a file --parquet-out wrote.
+func readParquetRows(t *testing.T, path string) []parquetRow {
+	t.Helper()
+	fr, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+	pr, err := reader.NewParquetReader(fr, new(parquetRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	rows := make([]parquetRow, pr.GetNumRows())
+	if err := pr.Read(&rows); err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestParquetExporterReadBack(t *testing.T) {
+	dir := t.TempDir()
+	exporter, closer, err := newParquetExporter(filepath.Join(dir, "logs.parquet"), 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := &QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.com", QType: "AAAA", TransID: 0x1234, Opcode: "QUERY"}
+	q.Fields = map[string]string{"site": "tokyo"}
+	r := &ResponseLog{QueryLog: *q, AnsIP: net.ParseIP("2001:db8::80"), IPv6Ready: true, AnsTypes: "1xAAAA"}
+	r.SrcIP, r.DstIP, r.SrcPort, r.DstPort = q.DstIP, q.SrcIP, q.DstPort, q.SrcPort
+	for _, l := range []telescreenLog{q, r, q} {
+		exporter(l)
+	}
+	closer()
+
+	// Two rows per file roll the third into another
+	first := readParquetRows(t, filepath.Join(dir, "logs-0001.parquet"))
+	second := readParquetRows(t, filepath.Join(dir, "logs-0002.parquet"))
+	if len(first) != 2 || len(second) != 1 {
+		t.Fatalf("read %d and %d rows, want 2 and 1", len(first), len(second))
+	}
+	query, resp := first[0], first[1]
+	if query.Kind != "query" || query.QueryString != "www.example.com" || query.QueryType != "AAAA" || query.TransactionID != 0x1234 || query.Opcode != "QUERY" || query.SrcIP != testClient || query.DstPort != 53 || query.Fields["site"] != "tokyo" {
+		t.Errorf("query = %+v", query)
+	}
+	if query.ReceivedAt != q.Timestamp.UnixNano()/1000 {
+		t.Errorf("query received at %d, want %d", query.ReceivedAt, q.Timestamp.UnixNano()/1000)
+	}
+	if resp.Kind != "response" || resp.AnswerIP != "2001:db8::80" || !resp.IPv6Ready || resp.AnswerTypes != "1xAAAA" || resp.SrcIP != testServer || resp.ClientIP != testClient {
+		t.Errorf("response = %+v", resp)
+	}
+	if !reflect.DeepEqual(second[0], query) {
+		t.Errorf("rolled row = %+v, want %+v", second[0], query)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by all the exporters, so that a log is
+// either exported everywhere or dropped everywhere. It holds a second's worth
+// of tokens at most, letting a short burst through at once.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // Tokens added per second
+	burst   float64
+	tokens  float64
+	last    time.Time
+	dropped uint64
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst}
+}
+
+// allow takes a token if any is left at the time, otherwise counts a drop.
+func (l *rateLimiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		atomic.AddUint64(&l.dropped, 1)
+		return false
+	}
+	l.tokens -= 1
+	return true
+}
+
+func (l *rateLimiter) droppedLogs() uint64 {
+	return atomic.LoadUint64(&l.dropped)
+}
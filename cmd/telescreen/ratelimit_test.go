@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	l := newRateLimiter(10)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// A second's worth of logs gets through at once, and no more
+	allowed := 0
+	for i := 0; i < 15; i++ {
+		if l.allow(start) {
+			allowed += 1
+		}
+	}
+	if allowed != 10 || l.droppedLogs() != 5 {
+		t.Errorf("burst allowed %d and dropped %d, want 10 and 5", allowed, l.droppedLogs())
+	}
+
+	// Driven at twice the rate, half of them gets through
+	allowed = 0
+	for i := 1; i <= 20; i++ {
+		if l.allow(start.Add(time.Duration(i) * 50 * time.Millisecond)) {
+			allowed += 1
+		}
+	}
+	if allowed != 10 || l.droppedLogs() != 15 {
+		t.Errorf("allowed %d and dropped %d in a second, want 10 and 15", allowed, l.droppedLogs())
+	}
+
+	// Idling refills up to the burst, not beyond
+	allowed = 0
+	for i := 0; i < 15; i++ {
+		if l.allow(start.Add(time.Minute)) {
+			allowed += 1
+		}
+	}
+	if allowed != 10 {
+		t.Errorf("allowed %d after idling, want 10", allowed)
+	}
+}
+
+func TestRateLimiterBelowOnePerSecond(t *testing.T) {
+	l := newRateLimiter(0.5)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		after time.Duration
+		want  bool
+	}{
+		{0, true},
+		{time.Second, false},
+		{2 * time.Second, true},
+		{3 * time.Second, false},
+	} {
+		if got := l.allow(start.Add(tt.after)); got != tt.want {
+			t.Errorf("allow() after %v = %v, want %v", tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestExportOverMaxRate(t *testing.T) {
+	defer func(l *rateLimiter) { limiter = l }(limiter)
+	limiter = newRateLimiter(5)
+
+	var exported []telescreenLog
+	q := &QueryLog{telescreenLogCommon: newTestCommon(), QString: "www.example.com", QType: "AAAA"}
+	for i := 0; i < 20; i++ {
+		export(q, []func(telescreenLog){func(l telescreenLog) { exported = append(exported, l) }})
+	}
+	if len(exported) != 5 {
+		t.Errorf("exported %d logs, want 5", len(exported))
+	}
+
+	var buf bytes.Buffer
+	newLatencyMetrics(maxTTLCacheEntries).writePrometheus(&buf)
+	if !strings.Contains(buf.String(), "telescreen_rate_limited_logs_total 15\n") {
+		t.Errorf("drops not exposed: %s", buf.String())
+	}
+}
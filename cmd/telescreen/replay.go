@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// replay accepts connections carrying pcap streams on --listen-replay, e.g.,
+// from agents running tcpdump -w - | nc, and passes each of them through the
+// pipeline as --read does a file. Logs are tagged with the address of the
+// agent. At most --replay-max-conns connections are handled at once, and the
+// others wait to be accepted.
+func replay(exporters []func(telescreenLog)) error {
+	listener, err := net.Listen("tcp", replayAddr)
+	if err != nil {
+		return fmt.Errorf("Failed to listen for replays: %w", err)
+	}
+	return serveReplays(listener, exporters)
+}
+
+// serveReplays accepts replays on the listener until a signal arrives, and
+// waits for those accepted to end.
+func serveReplays(listener net.Listener, exporters []func(telescreenLog)) error {
+	var mu sync.Mutex
+	stopping := false
+	conns := map[net.Conn]struct{}{}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	stop, done := shutdown, make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-sigs:
+		case <-stop:
+		case <-done:
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		stopping = true
+		listener.Close()
+		for conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	slots := make(chan struct{}, replayConns)
+	for {
+		slots <- struct{}{}
+		conn, err := listener.Accept()
+		if err != nil {
+			mu.Lock()
+			stopped := stopping
+			mu.Unlock()
+			if stopped {
+				return nil
+			}
+			return fmt.Errorf("Failed to accept replays: %w", err)
+		}
+
+		mu.Lock()
+		conns[conn] = struct{}{}
+		if stopping {
+			conn.Close()
+		}
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replayConn(conn, exporters)
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+			<-slots
+		}()
+	}
+}
+
+// replayConn reads a pcap stream from the connection until it ends.
+func replayConn(conn net.Conn, exporters []func(telescreenLog)) {
+	defer conn.Close()
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		host = conn.RemoteAddr().String()
+	}
+
+	reader, err := pcapgo.NewReader(conn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read pcap stream from %s: %v\n", host, err)
+		return
+	}
+	source := gopacket.NewPacketSource(reader, reader.LinkType())
+
+	packets := make(chan gopacket.Packet, 1000)
+	go func() {
+		defer close(packets)
+		if _, err := read(source, packets, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Replay from %s ended: %v\n", host, err)
+		}
+	}()
+
+	interceptFrom(host, "", packets, exporters)
+}
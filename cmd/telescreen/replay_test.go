@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func TestReplayFromAgent(t *testing.T) {
+	defer func(site string, conns int) { testSite, replayConns = site, conns }(testSite, replayConns)
+	testSite, replayConns = "tokyo", 1
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := make(chan telescreenLog, 2)
+	done := make(chan error)
+	go func() { done <- serveReplays(listener, []func(telescreenLog){func(l telescreenLog) { logs <- l }}) }()
+
+	// An agent streams a capture as tcpdump -w - does
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := pcapgo.NewWriter(conn)
+	if err := w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"first.example.com", "second.example.com"} {
+		packet := newQueryPacket(t, query(name, layers.DNSTypeAAAA))
+		data := packet.Data()
+		ci := packet.Metadata().CaptureInfo
+		ci.CaptureLength, ci.Length = len(data), len(data)
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Close()
+
+	var got []telescreenLog
+	for len(got) < 2 {
+		select {
+		case l := <-logs:
+			got = append(got, l)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d logs, want 2", len(got))
+		}
+	}
+	if names := queryNames(got); names != "first.example.com,second.example.com" {
+		t.Errorf("got %s, want both queries", names)
+	}
+	for _, l := range got {
+		// Tagged before the enrichers run
+		if c := logCommon(l); c.SourceHost != "127.0.0.1" || c.Fields["agent"] != "127.0.0.1" {
+			t.Errorf("got source host %q and agent %q, want 127.0.0.1", c.SourceHost, c.Fields["agent"])
+		}
+	}
+
+	listener.Close()
+	if err := <-done; err == nil {
+		t.Error("serveReplays() = nil after the listener failed")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Response codes by the names dig and tcpdump show
+var rcodes = map[string]layers.DNSResponseCode{
+	"NOERROR":  layers.DNSResponseCodeNoErr,
+	"FORMERR":  layers.DNSResponseCodeFormErr,
+	"SERVFAIL": layers.DNSResponseCodeServFail,
+	"NXDOMAIN": layers.DNSResponseCodeNXDomain,
+	"NOTIMP":   layers.DNSResponseCodeNotImp,
+	"REFUSED":  layers.DNSResponseCodeRefused,
+}
+
+// parseRcode accepts a response code by name, e.g., SERVFAIL, or by number.
+func parseRcode(s string) (layers.DNSResponseCode, error) {
+	if rcode, ok := rcodes[strings.ToUpper(s)]; ok {
+		return rcode, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown response code: %s", s)
+	}
+	return layers.DNSResponseCode(n), nil
+}
+
+type ringEntry struct {
+	at  time.Time
+	log telescreenLog
+}
+
+// triggerRing holds back recent logs until a packet matches the trigger, then
+// releases them as the context of the event, followed by the logs captured
+// within the window after it. The packet firing the trigger, e.g., a SERVFAIL
+// response, is exported only if it makes a log by itself.
+type triggerRing struct {
+	mu      sync.Mutex  // Replays are intercepted concurrently
+	entries []ringEntry // Circular, oldest at start
+	start   int
+	n       int
+	window  time.Duration // How long logs are kept before and passed after a trigger
+	until   time.Time     // End of the window after the last trigger
+
+	rcode  layers.DNSResponseCode
+	rcodes bool   // Whether to trigger on rcode
+	domain string // Trigger on names under the domain, unless empty
+}
+
+func newTriggerRing(size int, window time.Duration) *triggerRing {
+	return &triggerRing{
+		entries: make([]ringEntry, size),
+		window:  window,
+	}
+}
+
+// triggered reports whether the packet is an event to dump the logs for.
+func (r *triggerRing) triggered(packet gopacket.Packet) bool {
+	dnsLayer := packet.Layer(layers.LayerTypeDNS)
+	if dnsLayer == nil {
+		return false
+	}
+	dns, _ := dnsLayer.(*layers.DNS)
+	if r.rcodes && dns.QR && dns.ResponseCode == r.rcode {
+		return true
+	}
+	if r.domain != "" && len(dns.Questions) > 0 {
+		name := strings.ToLower(strings.TrimSuffix(string(dns.Questions[0].Name), "."))
+		return name == r.domain || strings.HasSuffix(name, "."+r.domain)
+	}
+	return false
+}
+
+// pass takes the log parsed from the packet, which may be nil, and returns the
+// logs to be exported now.
+func (r *triggerRing) pass(packet gopacket.Packet, log telescreenLog) []telescreenLog {
+	at := packet.Metadata().Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.triggered(packet) {
+		logs := make([]telescreenLog, 0, r.n+1)
+		for i := 0; i < r.n; i++ {
+			e := r.entries[(r.start+i)%len(r.entries)]
+			if !e.at.Before(at.Add(-r.window)) {
+				logs = append(logs, e.log)
+			}
+		}
+		r.start, r.n = 0, 0
+		r.until = at.Add(r.window)
+		if log != nil {
+			logs = append(logs, log)
+		}
+		return logs
+	}
+
+	if log == nil {
+		return nil
+	}
+	if !at.After(r.until) {
+		return []telescreenLog{log}
+	}
+
+	// Full, overwrite the oldest
+	if r.n == len(r.entries) {
+		r.start = (r.start + 1) % len(r.entries)
+		r.n -= 1
+	}
+	r.entries[(r.start+r.n)%len(r.entries)] = ringEntry{at: at, log: log}
+	r.n += 1
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// passAt passes a query for the name captured at the time through the ring,
+// and returns the names of the logs released.
+func passAt(t *testing.T, r *triggerRing, name string, at time.Time) string {
+	t.Helper()
+	packet := newQueryPacket(t, query(name, layers.DNSTypeAAAA))
+	packet.Metadata().Timestamp = at
+	return queryNames(r.pass(packet, &QueryLog{QString: name}))
+}
+
+func queryNames(logs []telescreenLog) string {
+	var ns []string
+	for _, l := range logs {
+		ns = append(ns, l.(*QueryLog).QString)
+	}
+	return strings.Join(ns, ",")
+}
+
+func TestTriggerRingBoundedByCount(t *testing.T) {
+	r := newTriggerRing(3, time.Minute)
+	r.domain = "trigger.example"
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, name := range []string{"1.example.com", "2.example.com", "3.example.com", "4.example.com", "5.example.com"} {
+		if got := passAt(t, r, name, start.Add(time.Duration(i)*time.Second)); got != "" {
+			t.Fatalf("%s released %s before a trigger", name, got)
+		}
+	}
+	got := passAt(t, r, "www.trigger.example", start.Add(5*time.Second))
+	if want := "3.example.com,4.example.com,5.example.com,www.trigger.example"; got != want {
+		t.Errorf("trigger released %s, want %s", got, want)
+	}
+}
+
+func TestTriggerRingBoundedByWindow(t *testing.T) {
+	r := newTriggerRing(100, 10*time.Second)
+	r.rcode, r.rcodes = layers.DNSResponseCodeServFail, true
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	passAt(t, r, "old.example.com", start)
+	passAt(t, r, "recent.example.com", start.Add(20*time.Second))
+
+	// The SERVFAIL response makes no log by itself, but releases what was
+	// held within the window before it
+	servfail := response("fail.example.com", layers.DNSTypeAAAA)
+	servfail.ResponseCode = layers.DNSResponseCodeServFail
+	packet := newResponsePacket(t, servfail, 25*time.Second)
+	if got := queryNames(r.pass(packet, nil)); got != "recent.example.com" {
+		t.Errorf("trigger released %s, want recent.example.com", got)
+	}
+
+	if got := passAt(t, r, "after.example.com", start.Add(30*time.Second)); got != "after.example.com" {
+		t.Errorf("released %q within the window after the trigger, want after.example.com", got)
+	}
+	if got := passAt(t, r, "later.example.com", start.Add(40*time.Second)); got != "" {
+		t.Errorf("released %s past the window after the trigger", got)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+const (
+	rpcapDefaultPort string        = "2002"           // Of rpcapd
+	rpcapDialTimeout time.Duration = 10 * time.Second // Bounds connecting to rpcapd and starting the capture
+
+	// Messages of the rpcap protocol, version 0, from rpcap-protocol.h of
+	// libpcap. A reply is the request with rpcapReply set.
+	rpcapMsgError     uint8  = 0x01
+	rpcapMsgOpen      uint8  = 0x03
+	rpcapMsgStartCap  uint8  = 0x04
+	rpcapMsgClose     uint8  = 0x06
+	rpcapMsgPacket    uint8  = 0x07
+	rpcapMsgAuth      uint8  = 0x08
+	rpcapReply        uint8  = 0x80
+	rpcapAuthNull     uint16 = 0
+	rpcapAuthPassword uint16 = 1
+	rpcapFlagPromisc  uint16 = 0x01
+	rpcapFilterBPF    uint16 = 1
+
+	rpcapHeaderLen    int    = 8
+	rpcapPktHeaderLen int    = 20
+	rpcapMaxPayload   uint32 = 1 << 24 // Beyond any snaplen, against a broken peer
+)
+
+// compileBPFFilter is replaced in tests, as libpcap may be built without the
+// filter compiler.
+var compileBPFFilter = pcap.CompileBPFFilter
+
+// rpcapSource is a remote interface given to --dev as rpcap://host[:port]/iface,
+// the form Wireshark and libpcap use.
+type rpcapSource struct {
+	host  string
+	port  string
+	iface string
+}
+
+func isRemoteDevice(dev string) bool {
+	return strings.HasPrefix(strings.ToLower(dev), "rpcap://")
+}
+
+// parseRPCAP checks the source before connecting to it. An IPv6 host is written
+// in brackets. Credentials are given by --rpcap-user and --rpcap-password
+// instead of in the source, which is printed in messages.
+func parseRPCAP(dev string) (*rpcapSource, error) {
+	u, err := url.Parse(dev)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(u.Scheme, "rpcap") {
+		return nil, fmt.Errorf("not an rpcap source: %s", dev)
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("credentials in the rpcap source are not supported, use --rpcap-user and --rpcap-password: %s", u.Redacted())
+	}
+
+	source := &rpcapSource{host: u.Hostname(), port: u.Port(), iface: strings.TrimPrefix(u.Path, "/")}
+	if source.host == "" {
+		return nil, fmt.Errorf("no host in the rpcap source: %s", dev)
+	}
+	if source.port == "" {
+		source.port = rpcapDefaultPort
+	}
+	if source.iface == "" {
+		return nil, fmt.Errorf("no interface in the rpcap source: %s", dev)
+	}
+	return source, nil
+}
+
+func (s *rpcapSource) String() string {
+	return fmt.Sprintf("rpcap://%s/%s", net.JoinHostPort(s.host, s.port), s.iface)
+}
+
+// rpcapHandle captures from a remote interface through rpcapd. It speaks the
+// rpcap protocol itself rather than through libpcap, which is rarely built
+// with remote capture support and cannot be given credentials by gopacket.
+// Packets arrive on a data connection opened to rpcapd after the capture
+// starts on the control connection.
+type rpcapHandle struct {
+	ctrl     net.Conn
+	data     net.Conn
+	r        *bufio.Reader // Of data
+	linkType layers.LinkType
+	once     sync.Once
+}
+
+// openRemote starts capturing on the remote interface of --dev with the BPF
+// filter, logging in to rpcapd with --rpcap-user and --rpcap-password if
+// given, or with null authentication, i.e., rpcapd -n, otherwise.
+func openRemote(snaplen int, f string) (*rpcapHandle, error) {
+	source, err := parseRPCAP(device)
+	if err != nil {
+		return nil, err
+	}
+	ctrl, err := net.DialTimeout("tcp", net.JoinHostPort(source.host, source.port), rpcapDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	h := &rpcapHandle{ctrl: ctrl}
+	if err = h.start(source.iface, snaplen, f); err != nil {
+		h.Close()
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	return h, nil
+}
+
+func (h *rpcapHandle) start(iface string, snaplen int, f string) error {
+	h.ctrl.SetDeadline(time.Now().Add(rpcapDialTimeout))
+	defer h.ctrl.SetDeadline(time.Time{})
+
+	auth := make([]byte, 8, 8+len(rpcapUser)+len(rpcapPassword))
+	if rpcapUser != "" {
+		if len(rpcapUser) > 0xffff || len(rpcapPassword) > 0xffff {
+			return errors.New("--rpcap-user or --rpcap-password is too long")
+		}
+		binary.BigEndian.PutUint16(auth[0:], rpcapAuthPassword)
+		binary.BigEndian.PutUint16(auth[4:], uint16(len(rpcapUser)))
+		binary.BigEndian.PutUint16(auth[6:], uint16(len(rpcapPassword)))
+		auth = append(append(auth, rpcapUser...), rpcapPassword...)
+	} else {
+		binary.BigEndian.PutUint16(auth[0:], rpcapAuthNull)
+	}
+	reply, err := h.request(rpcapMsgAuth, auth)
+	if err != nil {
+		return fmt.Errorf("failed to authenticate: %w", err)
+	}
+	// Version 0 is all there is, and older rpcapd replies without versions
+	if len(reply) >= 2 && reply[0] > 0 {
+		return fmt.Errorf("rpcapd supports protocol versions %d to %d only, not 0", reply[0], reply[1])
+	}
+
+	if reply, err = h.request(rpcapMsgOpen, []byte(iface)); err != nil {
+		return fmt.Errorf("failed to open %s: %w", iface, err)
+	}
+	if len(reply) < 8 {
+		return fmt.Errorf("short reply to opening %s", iface)
+	}
+	h.linkType = layers.LinkType(binary.BigEndian.Uint32(reply))
+
+	// rpcapd applies the filter, so it is compiled for the remote link type
+	insns, err := compileBPFFilter(h.linkType, snaplen, f)
+	if err != nil {
+		return fmt.Errorf("failed to compile BPF filter: %w", err)
+	}
+	req := make([]byte, 20, 20+8*len(insns))
+	binary.BigEndian.PutUint32(req[0:], uint32(snaplen))
+	binary.BigEndian.PutUint32(req[4:], uint32(timeout/time.Millisecond))
+	if promiscuous {
+		binary.BigEndian.PutUint16(req[8:], rpcapFlagPromisc)
+	}
+	binary.BigEndian.PutUint16(req[12:], rpcapFilterBPF)
+	binary.BigEndian.PutUint32(req[16:], uint32(len(insns)))
+	for _, insn := range insns {
+		var b [8]byte
+		binary.BigEndian.PutUint16(b[0:], insn.Code)
+		b[2], b[3] = insn.Jt, insn.Jf
+		binary.BigEndian.PutUint32(b[4:], insn.K)
+		req = append(req, b[:]...)
+	}
+	if reply, err = h.request(rpcapMsgStartCap, req); err != nil {
+		return fmt.Errorf("failed to start capturing on %s: %w", iface, err)
+	}
+	if len(reply) < 8 {
+		return fmt.Errorf("short reply to capturing on %s", iface)
+	}
+
+	// rpcapd waits for the data connection at the port it chose, on the same
+	// address as the control connection
+	port := binary.BigEndian.Uint16(reply[4:])
+	host := h.ctrl.RemoteAddr().(*net.TCPAddr).IP.String()
+	if h.data, err = net.DialTimeout("tcp", net.JoinHostPort(host, fmt.Sprint(port)), rpcapDialTimeout); err != nil {
+		return fmt.Errorf("failed to connect for data: %w", err)
+	}
+	h.r = bufio.NewReaderSize(h.data, 65536)
+	return nil
+}
+
+// request sends the message on the control connection, and returns the payload
+// of the reply, or what rpcapd tells about an error as an error.
+func (h *rpcapHandle) request(msg uint8, payload []byte) ([]byte, error) {
+	b := make([]byte, rpcapHeaderLen, rpcapHeaderLen+len(payload))
+	b[1] = msg
+	binary.BigEndian.PutUint32(b[4:], uint32(len(payload)))
+	if _, err := h.ctrl.Write(append(b, payload...)); err != nil {
+		return nil, err
+	}
+
+	typ, reply, err := readRPCAP(h.ctrl)
+	switch {
+	case err != nil:
+		return nil, err
+	case typ == rpcapMsgError:
+		return nil, fmt.Errorf("rpcapd: %s", strings.TrimRight(string(reply), "\x00"))
+	case typ != msg|rpcapReply:
+		return nil, fmt.Errorf("unexpected message %#x from rpcapd", typ)
+	}
+	return reply, nil
+}
+
+// readRPCAP reads a message, returning its type and payload.
+func readRPCAP(r io.Reader) (uint8, []byte, error) {
+	var header [rpcapHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	if header[0] != 0 {
+		return 0, nil, fmt.Errorf("unsupported rpcap version %d", header[0])
+	}
+	n := binary.BigEndian.Uint32(header[4:])
+	if n > rpcapMaxPayload {
+		return 0, nil, fmt.Errorf("rpcap message of %d bytes is too large", n)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[1], payload, nil
+}
+
+// ReadPacketData reads the next packet from the data connection. The end of
+// the connection is an error rather than io.EOF, which would end the capture
+// as if a pcap file was read through instead of reconnecting.
+func (h *rpcapHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		typ, payload, err := readRPCAP(h.r)
+		if errors.Is(err, io.EOF) {
+			err = errors.New("rpcapd closed the connection")
+		}
+		if err != nil {
+			return nil, gopacket.CaptureInfo{}, err
+		}
+		if typ != rpcapMsgPacket {
+			continue
+		}
+		if len(payload) < rpcapPktHeaderLen {
+			return nil, gopacket.CaptureInfo{}, errors.New("short packet from rpcapd")
+		}
+		caplen := int(binary.BigEndian.Uint32(payload[8:]))
+		if caplen > len(payload)-rpcapPktHeaderLen {
+			return nil, gopacket.CaptureInfo{}, fmt.Errorf("packet of %d bytes from rpcapd carries %d", caplen, len(payload)-rpcapPktHeaderLen)
+		}
+		ci := gopacket.CaptureInfo{
+			Timestamp:     time.Unix(int64(binary.BigEndian.Uint32(payload[0:])), int64(binary.BigEndian.Uint32(payload[4:]))*1000),
+			CaptureLength: caplen,
+			Length:        int(binary.BigEndian.Uint32(payload[12:])),
+		}
+		return payload[rpcapPktHeaderLen : rpcapPktHeaderLen+caplen], ci, nil
+	}
+}
+
+func (h *rpcapHandle) LinkType() layers.LinkType {
+	return h.linkType
+}
+
+// Close tells rpcapd to end the capture, and unblocks a read in progress.
+func (h *rpcapHandle) Close() {
+	h.once.Do(func() {
+		h.ctrl.SetWriteDeadline(time.Now().Add(timeout))
+		h.ctrl.Write([]byte{0, rpcapMsgClose, 0, 0, 0, 0, 0, 0})
+		h.ctrl.Close()
+		if h.data != nil {
+			h.data.Close()
+		}
+	})
+}
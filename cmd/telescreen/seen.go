@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// seenDomains is the set of names ever queried, for --only-new-domains. It is
+// kept in memory, and persisted to a file of "<unix time> <quoted name>" lines
+// appended to as names are first seen, so that a name seen before a restart is
+// still known after it. The file is rewritten with the time each name was last
+// seen on open and close. Names not seen again for the TTL are forgotten and
+// become new again, unless it is 0. So are the least recently seen ones beyond
+// the size, so that a flood of unique names cannot exhaust memory.
+type seenDomains struct {
+	mu    sync.Mutex
+	names *boundedCache // Of the time last seen
+	path  string
+	f     *os.File // nil without --seen-db
+}
+
+// openSeenDomains loads the file at the path, if any, and compacts it to the
+// names not expired yet before appending to it.
+func openSeenDomains(path string, size int, ttl time.Duration) (*seenDomains, error) {
+	s := &seenDomains{names: newBoundedCache("seen_domains", size, ttl), path: path}
+	if path == "" {
+		return s, nil
+	}
+
+	if err := s.load(path, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+	return s, nil
+}
+
+// compact replaces the file with the names in memory, least recently seen
+// first so that they are evicted first after loading it again, through a
+// temporary file so that a crash leaves either of them whole.
+func (s *seenDomains) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	s.names.walk(func(name string, value interface{}, at time.Time) {
+		fmt.Fprintf(w, "%d %s\n", at.Unix(), strconv.Quote(name))
+	})
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// load reads the names not expired at the time. Lines written halfway, e.g.,
+// on a crash, are skipped.
+func (s *seenDomains) load(path string, now time.Time) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		unix, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		name, err := strconv.Unquote(fields[1])
+		if err != nil {
+			continue
+		}
+		at := time.Unix(unix, 0)
+		if s.names.ttl > 0 && now.Sub(at) > s.names.ttl {
+			continue
+		}
+		if last, ok := s.names.get(name, now); ok && !at.After(last.(time.Time)) {
+			continue
+		}
+		s.names.put(name, at, at)
+	}
+	return scanner.Err()
+}
+
+// first reports whether the name has never been seen, or not within the TTL,
+// and records it either way. Names are compared regardless of case and the
+// trailing dot.
+func (s *seenDomains) first(name string, at time.Time) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.names.get(name, at)
+	// Refreshed in memory only until the file is rewritten on close
+	s.names.put(name, at, at)
+	if ok {
+		return false
+	}
+	if s.f != nil {
+		if _, err := fmt.Fprintf(s.f, "%d %s\n", at.Unix(), strconv.Quote(name)); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write --seen-db: %v\n", err)
+		}
+	}
+	return true
+}
+
+func (s *seenDomains) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	s.f = nil
+	return s.compact()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSeenDomainsAcrossRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.db")
+	now := time.Now()
+
+	s, err := openSeenDomains(path, 100, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.first("www.example.com", now) {
+		t.Error("www.example.com not new at first")
+	}
+	if s.first("WWW.example.com.", now) {
+		t.Error("www.example.com new again regardless of case and the trailing dot")
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lines of a name long expired and written halfway on a crash
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("1000000000 \"old.example.com\"\n1700000000 \"trunc")
+	f.Close()
+
+	// Restarted
+	s, err = openSeenDomains(path, 100, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if s.first("www.example.com", now.Add(time.Hour)) {
+		t.Error("www.example.com new again after the restart")
+	}
+	if !s.first("old.example.com", now.Add(time.Hour)) {
+		t.Error("old.example.com not new again after the TTL")
+	}
+	if !s.first("new.example.com", now.Add(time.Hour)) {
+		t.Error("new.example.com not new")
+	}
+}
+
+func TestSeenDomainsBounded(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s, err := openSeenDomains("", 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a TTL, only the size forgets a name, the least recently seen
+	s.first("a.example.com", now)
+	s.first("b.example.com", now)
+	s.first("a.example.com", now.Add(time.Second))
+	s.first("c.example.com", now.Add(2*time.Second))
+	if s.names.len() != 2 || s.names.evictions != 1 {
+		t.Errorf("%d names and %d evictions, want 2 and 1", s.names.len(), s.names.evictions)
+	}
+	if s.first("a.example.com", now.Add(1000*time.Hour)) {
+		t.Error("a.example.com seen recently forgotten")
+	}
+	if !s.first("b.example.com", now.Add(1000*time.Hour)) {
+		t.Error("b.example.com seen least recently not forgotten")
+	}
+}
@@ -0,0 +1,75 @@
+// Package enrich lets code outside telescreen tag each log before it is
+// exported, e.g., with the site it was captured at or the ASN of the client.
+//
+// An enricher is registered from the init function of its package, which is
+// linked into telescreen by a blank import in a file added to cmd/telescreen:
+//
+//	package main
+//
+//	import _ "example.com/telescreen-site"
+package enrich
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// Record is a log as enrichers see it. Fields is what enrichers add, and every
+// exporter carries it along with the log. The other fields describe the log
+// and changes to them are not exported.
+type Record struct {
+	Timestamp  time.Time
+	Transport  string // udp, tcp or dot
+	ClientIP   net.IP
+	ClientPort uint16
+	ServerIP   net.IP
+	ServerPort uint16
+	SourceHost string // Agent that streamed the packet with --listen-replay
+
+	Response  bool   // Whether the log is of a response
+	QueryName string // Empty for DNS over TLS
+	QueryType string
+	AnswerIP  net.IP // Of a response, nil without an answer
+	SNI       string // Of DNS over TLS
+
+	Fields map[string]string
+}
+
+// Enricher runs on each log after parsing and before exporting, along with the
+// packet the log was parsed from. It returns the record to be passed on, which
+// is usually the one given with fields added, or nil to drop the log.
+type Enricher func(packet gopacket.Packet, r *Record) *Record
+
+var (
+	mu        sync.RWMutex
+	enrichers []Enricher
+)
+
+// Register adds the enricher to run after those registered before.
+func Register(e Enricher) {
+	mu.Lock()
+	defer mu.Unlock()
+	enrichers = append(enrichers, e)
+}
+
+// Len returns the number of enrichers registered.
+func Len() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(enrichers)
+}
+
+// Run runs the enrichers in order, stopping once one of them drops the log.
+func Run(packet gopacket.Packet, r *Record) *Record {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, e := range enrichers {
+		if r = e(packet, r); r == nil {
+			return nil
+		}
+	}
+	return r
+}
@@ -0,0 +1,32 @@
+package enrich_test
+
+import (
+	"fmt"
+
+	"github.com/google/gopacket"
+	"github.com/wide-vsix/telescreen/enrich"
+)
+
+// Tags each log with the site it was captured at, and drops queries for
+// names nobody wants to see.
+func Example() {
+	enrich.Register(func(packet gopacket.Packet, r *enrich.Record) *enrich.Record {
+		if r.QueryName == "noise.example.com" {
+			return nil
+		}
+		r.Fields["site"] = "tokyo"
+		return r
+	})
+
+	for _, name := range []string{"www.example.com", "noise.example.com"} {
+		r := enrich.Run(nil, &enrich.Record{QueryName: name, QueryType: "AAAA", Fields: map[string]string{}})
+		if r == nil {
+			fmt.Printf("%s dropped\n", name)
+			continue
+		}
+		fmt.Printf("%s at %s\n", r.QueryName, r.Fields["site"])
+	}
+	// Output:
+	// www.example.com at tokyo
+	// noise.example.com dropped
+}
@@ -2,19 +2,37 @@ module github.com/wide-vsix/telescreen
 
 go 1.17
 
-require github.com/google/gopacket v1.1.19
+require (
+	github.com/DATA-DOG/go-sqlmock v1.5.0
+	github.com/go-pg/pg/v10 v10.10.3
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/google/gopacket v1.1.19
+	github.com/spf13/pflag v1.0.5
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
+	google.golang.org/grpc v1.50.1
+	google.golang.org/protobuf v1.28.1
+)
 
 require (
-	github.com/go-pg/pg/v10 v10.10.3 // indirect
+	github.com/apache/arrow/go/arrow v0.0.0-20200730104253-651201b0f516 // indirect
+	github.com/apache/thrift v0.14.2 // indirect
 	github.com/go-pg/zerochecker v0.2.0 // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/golang/snappy v0.0.3 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/klauspost/compress v1.13.1 // indirect
+	github.com/pierrec/lz4/v4 v4.1.8 // indirect
 	github.com/tmthrgd/go-hex v0.0.0-20190904060850-447a3041c3bc // indirect
 	github.com/vmihailenco/bufpool v0.1.11 // indirect
 	github.com/vmihailenco/msgpack/v5 v5.3.1 // indirect
 	github.com/vmihailenco/tagparser v0.1.2 // indirect
 	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b // indirect
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110 // indirect
 	golang.org/x/sys v0.0.0-20210423185535-09eb48e85fd7 // indirect
+	golang.org/x/text v0.3.3 // indirect
+	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013 // indirect
 	mellium.im/sasl v0.2.1 // indirect
 )
@@ -0,0 +1,666 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.1
+// 	protoc        (unknown)
+// source: telescreenpb/telescreen.proto
+
+package telescreenpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Filter is evaluated on the server side. Empty fields match everything.
+type Filter struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	QueryTypes     []string `protobuf:"bytes,1,rep,name=query_types,json=queryTypes,proto3" json:"query_types,omitempty"`             // e.g., "A" and "AAAA"
+	DomainSuffixes []string `protobuf:"bytes,2,rep,name=domain_suffixes,json=domainSuffixes,proto3" json:"domain_suffixes,omitempty"` // e.g., "example.com" matching its subdomains, or the SNI of DNS over TLS
+}
+
+func (x *Filter) Reset() {
+	*x = Filter{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_telescreenpb_telescreen_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Filter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Filter) ProtoMessage() {}
+
+func (x *Filter) ProtoReflect() protoreflect.Message {
+	mi := &file_telescreenpb_telescreen_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Filter.ProtoReflect.Descriptor instead.
+func (*Filter) Descriptor() ([]byte, []int) {
+	return file_telescreenpb_telescreen_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Filter) GetQueryTypes() []string {
+	if x != nil {
+		return x.QueryTypes
+	}
+	return nil
+}
+
+func (x *Filter) GetDomainSuffixes() []string {
+	if x != nil {
+		return x.DomainSuffixes
+	}
+	return nil
+}
+
+// DnsEvent mirrors a query or response log.
+type DnsEvent struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ReceivedAt       *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=received_at,json=receivedAt,proto3" json:"received_at,omitempty"`
+	SrcIp            string                 `protobuf:"bytes,2,opt,name=src_ip,json=srcIp,proto3" json:"src_ip,omitempty"`
+	DstIp            string                 `protobuf:"bytes,3,opt,name=dst_ip,json=dstIp,proto3" json:"dst_ip,omitempty"`
+	SrcPort          uint32                 `protobuf:"varint,4,opt,name=src_port,json=srcPort,proto3" json:"src_port,omitempty"`
+	DstPort          uint32                 `protobuf:"varint,5,opt,name=dst_port,json=dstPort,proto3" json:"dst_port,omitempty"`
+	TcpTransport     bool                   `protobuf:"varint,6,opt,name=tcp_transport,json=tcpTransport,proto3" json:"tcp_transport,omitempty"`
+	QueryString      string                 `protobuf:"bytes,7,opt,name=query_string,json=queryString,proto3" json:"query_string,omitempty"`
+	QueryType        string                 `protobuf:"bytes,8,opt,name=query_type,json=queryType,proto3" json:"query_type,omitempty"`
+	Response         bool                   `protobuf:"varint,9,opt,name=response,proto3" json:"response,omitempty"`
+	AnswerIp         string                 `protobuf:"bytes,10,opt,name=answer_ip,json=answerIp,proto3" json:"answer_ip,omitempty"`
+	Ipv6Ready        bool                   `protobuf:"varint,11,opt,name=ipv6_ready,json=ipv6Ready,proto3" json:"ipv6_ready,omitempty"`
+	LikelyCached     bool                   `protobuf:"varint,12,opt,name=likely_cached,json=likelyCached,proto3" json:"likely_cached,omitempty"`
+	AnswerTypes      string                 `protobuf:"bytes,13,opt,name=answer_types,json=answerTypes,proto3" json:"answer_types,omitempty"`
+	ClientIp         string                 `protobuf:"bytes,14,opt,name=client_ip,json=clientIp,proto3" json:"client_ip,omitempty"`
+	ClientPort       uint32                 `protobuf:"varint,15,opt,name=client_port,json=clientPort,proto3" json:"client_port,omitempty"`
+	ServerIp         string                 `protobuf:"bytes,16,opt,name=server_ip,json=serverIp,proto3" json:"server_ip,omitempty"`
+	ServerPort       uint32                 `protobuf:"varint,17,opt,name=server_port,json=serverPort,proto3" json:"server_port,omitempty"`
+	PtrAddress       string                 `protobuf:"bytes,18,opt,name=ptr_address,json=ptrAddress,proto3" json:"ptr_address,omitempty"`
+	AnswerSection    string                 `protobuf:"bytes,19,opt,name=answer_section,json=answerSection,proto3" json:"answer_section,omitempty"`
+	Transport        string                 `protobuf:"bytes,20,opt,name=transport,proto3" json:"transport,omitempty"`
+	TransactionId    uint32                 `protobuf:"varint,21,opt,name=transaction_id,json=transactionId,proto3" json:"transaction_id,omitempty"`
+	Sni              string                 `protobuf:"bytes,22,opt,name=sni,proto3" json:"sni,omitempty"`                                                                                               // Server name of a DNS over TLS handshake with --dot, which has no query fields
+	Fields           map[string]string      `protobuf:"bytes,23,rep,name=fields,proto3" json:"fields,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"` // Added by enrichers
+	ServerId         string                 `protobuf:"bytes,24,opt,name=server_id,json=serverId,proto3" json:"server_id,omitempty"`
+	Opcode           string                 `protobuf:"bytes,25,opt,name=opcode,proto3" json:"opcode,omitempty"` // QUERY, NOTIFY, UPDATE and so on
+	CnameHops        uint32                 `protobuf:"varint,26,opt,name=cname_hops,json=cnameHops,proto3" json:"cname_hops,omitempty"`
+	SourceHost       string                 `protobuf:"bytes,27,opt,name=source_host,json=sourceHost,proto3" json:"source_host,omitempty"` // Agent that streamed the packet with --listen-replay
+	NewAnswer        bool                   `protobuf:"varint,28,opt,name=new_answer,json=newAnswer,proto3" json:"new_answer,omitempty"`   // Answer unlike those seen recently, with --track-answer-churn
+	ExtendedErrors   []*ExtendedError       `protobuf:"bytes,29,rep,name=extended_errors,json=extendedErrors,proto3" json:"extended_errors,omitempty"`
+	Mismatch         bool                   `protobuf:"varint,30,opt,name=mismatch,proto3" json:"mismatch,omitempty"`                   // Answer differs from that of --compare-resolver
+	RefAnswer        string                 `protobuf:"bytes,31,opt,name=ref_answer,json=refAnswer,proto3" json:"ref_answer,omitempty"` // Addresses --compare-resolver answered, comma separated
+	CaptureHost      string                 `protobuf:"bytes,32,opt,name=capture_host,json=captureHost,proto3" json:"capture_host,omitempty"`
+	CaptureInterface string                 `protobuf:"bytes,33,opt,name=capture_interface,json=captureInterface,proto3" json:"capture_interface,omitempty"` // Empty when read from a file
+	Combined         bool                   `protobuf:"varint,34,opt,name=combined,proto3" json:"combined,omitempty"`                                        // A query and its response in one event with --combined
+	LatencyUs        int64                  `protobuf:"varint,35,opt,name=latency_us,json=latencyUs,proto3" json:"latency_us,omitempty"`                     // From the query to the response with --combined
+	Timeout          bool                   `protobuf:"varint,36,opt,name=timeout,proto3" json:"timeout,omitempty"`                                          // No response in time with --combined
+}
+
+func (x *DnsEvent) Reset() {
+	*x = DnsEvent{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_telescreenpb_telescreen_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DnsEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DnsEvent) ProtoMessage() {}
+
+func (x *DnsEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_telescreenpb_telescreen_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DnsEvent.ProtoReflect.Descriptor instead.
+func (*DnsEvent) Descriptor() ([]byte, []int) {
+	return file_telescreenpb_telescreen_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *DnsEvent) GetReceivedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ReceivedAt
+	}
+	return nil
+}
+
+func (x *DnsEvent) GetSrcIp() string {
+	if x != nil {
+		return x.SrcIp
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetDstIp() string {
+	if x != nil {
+		return x.DstIp
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetSrcPort() uint32 {
+	if x != nil {
+		return x.SrcPort
+	}
+	return 0
+}
+
+func (x *DnsEvent) GetDstPort() uint32 {
+	if x != nil {
+		return x.DstPort
+	}
+	return 0
+}
+
+func (x *DnsEvent) GetTcpTransport() bool {
+	if x != nil {
+		return x.TcpTransport
+	}
+	return false
+}
+
+func (x *DnsEvent) GetQueryString() string {
+	if x != nil {
+		return x.QueryString
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetQueryType() string {
+	if x != nil {
+		return x.QueryType
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetResponse() bool {
+	if x != nil {
+		return x.Response
+	}
+	return false
+}
+
+func (x *DnsEvent) GetAnswerIp() string {
+	if x != nil {
+		return x.AnswerIp
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetIpv6Ready() bool {
+	if x != nil {
+		return x.Ipv6Ready
+	}
+	return false
+}
+
+func (x *DnsEvent) GetLikelyCached() bool {
+	if x != nil {
+		return x.LikelyCached
+	}
+	return false
+}
+
+func (x *DnsEvent) GetAnswerTypes() string {
+	if x != nil {
+		return x.AnswerTypes
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetClientIp() string {
+	if x != nil {
+		return x.ClientIp
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetClientPort() uint32 {
+	if x != nil {
+		return x.ClientPort
+	}
+	return 0
+}
+
+func (x *DnsEvent) GetServerIp() string {
+	if x != nil {
+		return x.ServerIp
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetServerPort() uint32 {
+	if x != nil {
+		return x.ServerPort
+	}
+	return 0
+}
+
+func (x *DnsEvent) GetPtrAddress() string {
+	if x != nil {
+		return x.PtrAddress
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetAnswerSection() string {
+	if x != nil {
+		return x.AnswerSection
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetTransport() string {
+	if x != nil {
+		return x.Transport
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetTransactionId() uint32 {
+	if x != nil {
+		return x.TransactionId
+	}
+	return 0
+}
+
+func (x *DnsEvent) GetSni() string {
+	if x != nil {
+		return x.Sni
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetFields() map[string]string {
+	if x != nil {
+		return x.Fields
+	}
+	return nil
+}
+
+func (x *DnsEvent) GetServerId() string {
+	if x != nil {
+		return x.ServerId
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetOpcode() string {
+	if x != nil {
+		return x.Opcode
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetCnameHops() uint32 {
+	if x != nil {
+		return x.CnameHops
+	}
+	return 0
+}
+
+func (x *DnsEvent) GetSourceHost() string {
+	if x != nil {
+		return x.SourceHost
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetNewAnswer() bool {
+	if x != nil {
+		return x.NewAnswer
+	}
+	return false
+}
+
+func (x *DnsEvent) GetExtendedErrors() []*ExtendedError {
+	if x != nil {
+		return x.ExtendedErrors
+	}
+	return nil
+}
+
+func (x *DnsEvent) GetMismatch() bool {
+	if x != nil {
+		return x.Mismatch
+	}
+	return false
+}
+
+func (x *DnsEvent) GetRefAnswer() string {
+	if x != nil {
+		return x.RefAnswer
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetCaptureHost() string {
+	if x != nil {
+		return x.CaptureHost
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetCaptureInterface() string {
+	if x != nil {
+		return x.CaptureInterface
+	}
+	return ""
+}
+
+func (x *DnsEvent) GetCombined() bool {
+	if x != nil {
+		return x.Combined
+	}
+	return false
+}
+
+func (x *DnsEvent) GetLatencyUs() int64 {
+	if x != nil {
+		return x.LatencyUs
+	}
+	return 0
+}
+
+func (x *DnsEvent) GetTimeout() bool {
+	if x != nil {
+		return x.Timeout
+	}
+	return false
+}
+
+// ExtendedError is an Extended DNS Error of RFC 8914 in a response.
+type ExtendedError struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	InfoCode  uint32 `protobuf:"varint,1,opt,name=info_code,json=infoCode,proto3" json:"info_code,omitempty"` // e.g., 15 for Blocked
+	ExtraText string `protobuf:"bytes,2,opt,name=extra_text,json=extraText,proto3" json:"extra_text,omitempty"`
+}
+
+func (x *ExtendedError) Reset() {
+	*x = ExtendedError{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_telescreenpb_telescreen_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ExtendedError) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExtendedError) ProtoMessage() {}
+
+func (x *ExtendedError) ProtoReflect() protoreflect.Message {
+	mi := &file_telescreenpb_telescreen_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExtendedError.ProtoReflect.Descriptor instead.
+func (*ExtendedError) Descriptor() ([]byte, []int) {
+	return file_telescreenpb_telescreen_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ExtendedError) GetInfoCode() uint32 {
+	if x != nil {
+		return x.InfoCode
+	}
+	return 0
+}
+
+func (x *ExtendedError) GetExtraText() string {
+	if x != nil {
+		return x.ExtraText
+	}
+	return ""
+}
+
+var File_telescreenpb_telescreen_proto protoreflect.FileDescriptor
+
+var file_telescreenpb_telescreen_proto_rawDesc = []byte{
+	0x0a, 0x1d, 0x74, 0x65, 0x6c, 0x65, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x70, 0x62, 0x2f, 0x74,
+	0x65, 0x6c, 0x65, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12,
+	0x0a, 0x74, 0x65, 0x6c, 0x65, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x1a, 0x1f, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x52, 0x0a, 0x06,
+	0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x12, 0x1f, 0x0a, 0x0b, 0x71, 0x75, 0x65, 0x72, 0x79, 0x5f,
+	0x74, 0x79, 0x70, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0a, 0x71, 0x75, 0x65,
+	0x72, 0x79, 0x54, 0x79, 0x70, 0x65, 0x73, 0x12, 0x27, 0x0a, 0x0f, 0x64, 0x6f, 0x6d, 0x61, 0x69,
+	0x6e, 0x5f, 0x73, 0x75, 0x66, 0x66, 0x69, 0x78, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09,
+	0x52, 0x0e, 0x64, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x53, 0x75, 0x66, 0x66, 0x69, 0x78, 0x65, 0x73,
+	0x22, 0xfa, 0x09, 0x0a, 0x08, 0x44, 0x6e, 0x73, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x3b, 0x0a,
+	0x0b, 0x72, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0a,
+	0x72, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x64, 0x41, 0x74, 0x12, 0x15, 0x0a, 0x06, 0x73, 0x72,
+	0x63, 0x5f, 0x69, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x73, 0x72, 0x63, 0x49,
+	0x70, 0x12, 0x15, 0x0a, 0x06, 0x64, 0x73, 0x74, 0x5f, 0x69, 0x70, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x05, 0x64, 0x73, 0x74, 0x49, 0x70, 0x12, 0x19, 0x0a, 0x08, 0x73, 0x72, 0x63, 0x5f,
+	0x70, 0x6f, 0x72, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x73, 0x72, 0x63, 0x50,
+	0x6f, 0x72, 0x74, 0x12, 0x19, 0x0a, 0x08, 0x64, 0x73, 0x74, 0x5f, 0x70, 0x6f, 0x72, 0x74, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x64, 0x73, 0x74, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x23,
+	0x0a, 0x0d, 0x74, 0x63, 0x70, 0x5f, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x18,
+	0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x74, 0x63, 0x70, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x70,
+	0x6f, 0x72, 0x74, 0x12, 0x21, 0x0a, 0x0c, 0x71, 0x75, 0x65, 0x72, 0x79, 0x5f, 0x73, 0x74, 0x72,
+	0x69, 0x6e, 0x67, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x71, 0x75, 0x65, 0x72, 0x79,
+	0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x12, 0x1d, 0x0a, 0x0a, 0x71, 0x75, 0x65, 0x72, 0x79, 0x5f,
+	0x74, 0x79, 0x70, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x71, 0x75, 0x65, 0x72,
+	0x79, 0x54, 0x79, 0x70, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x1b, 0x0a, 0x09, 0x61, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x5f, 0x69, 0x70, 0x18, 0x0a,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x61, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x49, 0x70, 0x12, 0x1d,
+	0x0a, 0x0a, 0x69, 0x70, 0x76, 0x36, 0x5f, 0x72, 0x65, 0x61, 0x64, 0x79, 0x18, 0x0b, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x09, 0x69, 0x70, 0x76, 0x36, 0x52, 0x65, 0x61, 0x64, 0x79, 0x12, 0x23, 0x0a,
+	0x0d, 0x6c, 0x69, 0x6b, 0x65, 0x6c, 0x79, 0x5f, 0x63, 0x61, 0x63, 0x68, 0x65, 0x64, 0x18, 0x0c,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x6c, 0x69, 0x6b, 0x65, 0x6c, 0x79, 0x43, 0x61, 0x63, 0x68,
+	0x65, 0x64, 0x12, 0x21, 0x0a, 0x0c, 0x61, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x5f, 0x74, 0x79, 0x70,
+	0x65, 0x73, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x61, 0x6e, 0x73, 0x77, 0x65, 0x72,
+	0x54, 0x79, 0x70, 0x65, 0x73, 0x12, 0x1b, 0x0a, 0x09, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x5f,
+	0x69, 0x70, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74,
+	0x49, 0x70, 0x12, 0x1f, 0x0a, 0x0b, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x5f, 0x70, 0x6f, 0x72,
+	0x74, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0a, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x50,
+	0x6f, 0x72, 0x74, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x5f, 0x69, 0x70,
+	0x18, 0x10, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x49, 0x70,
+	0x12, 0x1f, 0x0a, 0x0b, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x5f, 0x70, 0x6f, 0x72, 0x74, 0x18,
+	0x11, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0a, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x50, 0x6f, 0x72,
+	0x74, 0x12, 0x1f, 0x0a, 0x0b, 0x70, 0x74, 0x72, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73,
+	0x18, 0x12, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x70, 0x74, 0x72, 0x41, 0x64, 0x64, 0x72, 0x65,
+	0x73, 0x73, 0x12, 0x25, 0x0a, 0x0e, 0x61, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x5f, 0x73, 0x65, 0x63,
+	0x74, 0x69, 0x6f, 0x6e, 0x18, 0x13, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x61, 0x6e, 0x73, 0x77,
+	0x65, 0x72, 0x53, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x1c, 0x0a, 0x09, 0x74, 0x72, 0x61,
+	0x6e, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x14, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x74, 0x72,
+	0x61, 0x6e, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x12, 0x25, 0x0a, 0x0e, 0x74, 0x72, 0x61, 0x6e, 0x73,
+	0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x15, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x0d, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x12, 0x10,
+	0x0a, 0x03, 0x73, 0x6e, 0x69, 0x18, 0x16, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x73, 0x6e, 0x69,
+	0x12, 0x38, 0x0a, 0x06, 0x66, 0x69, 0x65, 0x6c, 0x64, 0x73, 0x18, 0x17, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x20, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x2e, 0x44, 0x6e,
+	0x73, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x2e, 0x46, 0x69, 0x65, 0x6c, 0x64, 0x73, 0x45, 0x6e, 0x74,
+	0x72, 0x79, 0x52, 0x06, 0x66, 0x69, 0x65, 0x6c, 0x64, 0x73, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x65,
+	0x72, 0x76, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x18, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73,
+	0x65, 0x72, 0x76, 0x65, 0x72, 0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x6f, 0x70, 0x63, 0x6f, 0x64,
+	0x65, 0x18, 0x19, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x6f, 0x70, 0x63, 0x6f, 0x64, 0x65, 0x12,
+	0x1d, 0x0a, 0x0a, 0x63, 0x6e, 0x61, 0x6d, 0x65, 0x5f, 0x68, 0x6f, 0x70, 0x73, 0x18, 0x1a, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x09, 0x63, 0x6e, 0x61, 0x6d, 0x65, 0x48, 0x6f, 0x70, 0x73, 0x12, 0x1f,
+	0x0a, 0x0b, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x5f, 0x68, 0x6f, 0x73, 0x74, 0x18, 0x1b, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0a, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x48, 0x6f, 0x73, 0x74, 0x12,
+	0x1d, 0x0a, 0x0a, 0x6e, 0x65, 0x77, 0x5f, 0x61, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x18, 0x1c, 0x20,
+	0x01, 0x28, 0x08, 0x52, 0x09, 0x6e, 0x65, 0x77, 0x41, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x12, 0x42,
+	0x0a, 0x0f, 0x65, 0x78, 0x74, 0x65, 0x6e, 0x64, 0x65, 0x64, 0x5f, 0x65, 0x72, 0x72, 0x6f, 0x72,
+	0x73, 0x18, 0x1d, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x73, 0x63,
+	0x72, 0x65, 0x65, 0x6e, 0x2e, 0x45, 0x78, 0x74, 0x65, 0x6e, 0x64, 0x65, 0x64, 0x45, 0x72, 0x72,
+	0x6f, 0x72, 0x52, 0x0e, 0x65, 0x78, 0x74, 0x65, 0x6e, 0x64, 0x65, 0x64, 0x45, 0x72, 0x72, 0x6f,
+	0x72, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x6d, 0x69, 0x73, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x18, 0x1e,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x6d, 0x69, 0x73, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x12, 0x1d,
+	0x0a, 0x0a, 0x72, 0x65, 0x66, 0x5f, 0x61, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x18, 0x1f, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x09, 0x72, 0x65, 0x66, 0x41, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x12, 0x21, 0x0a,
+	0x0c, 0x63, 0x61, 0x70, 0x74, 0x75, 0x72, 0x65, 0x5f, 0x68, 0x6f, 0x73, 0x74, 0x18, 0x20, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0b, 0x63, 0x61, 0x70, 0x74, 0x75, 0x72, 0x65, 0x48, 0x6f, 0x73, 0x74,
+	0x12, 0x2b, 0x0a, 0x11, 0x63, 0x61, 0x70, 0x74, 0x75, 0x72, 0x65, 0x5f, 0x69, 0x6e, 0x74, 0x65,
+	0x72, 0x66, 0x61, 0x63, 0x65, 0x18, 0x21, 0x20, 0x01, 0x28, 0x09, 0x52, 0x10, 0x63, 0x61, 0x70,
+	0x74, 0x75, 0x72, 0x65, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63, 0x65, 0x12, 0x1a, 0x0a,
+	0x08, 0x63, 0x6f, 0x6d, 0x62, 0x69, 0x6e, 0x65, 0x64, 0x18, 0x22, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x08, 0x63, 0x6f, 0x6d, 0x62, 0x69, 0x6e, 0x65, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x6c, 0x61, 0x74,
+	0x65, 0x6e, 0x63, 0x79, 0x5f, 0x75, 0x73, 0x18, 0x23, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x6c,
+	0x61, 0x74, 0x65, 0x6e, 0x63, 0x79, 0x55, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x74, 0x69, 0x6d, 0x65,
+	0x6f, 0x75, 0x74, 0x18, 0x24, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x74, 0x69, 0x6d, 0x65, 0x6f,
+	0x75, 0x74, 0x1a, 0x39, 0x0a, 0x0b, 0x46, 0x69, 0x65, 0x6c, 0x64, 0x73, 0x45, 0x6e, 0x74, 0x72,
+	0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03,
+	0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x4b, 0x0a,
+	0x0d, 0x45, 0x78, 0x74, 0x65, 0x6e, 0x64, 0x65, 0x64, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x12, 0x1b,
+	0x0a, 0x09, 0x69, 0x6e, 0x66, 0x6f, 0x5f, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x08, 0x69, 0x6e, 0x66, 0x6f, 0x43, 0x6f, 0x64, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x65,
+	0x78, 0x74, 0x72, 0x61, 0x5f, 0x74, 0x65, 0x78, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x09, 0x65, 0x78, 0x74, 0x72, 0x61, 0x54, 0x65, 0x78, 0x74, 0x32, 0x45, 0x0a, 0x0a, 0x54, 0x65,
+	0x6c, 0x65, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x12, 0x37, 0x0a, 0x09, 0x53, 0x75, 0x62, 0x73,
+	0x63, 0x72, 0x69, 0x62, 0x65, 0x12, 0x12, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x73, 0x63, 0x72, 0x65,
+	0x65, 0x6e, 0x2e, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x1a, 0x14, 0x2e, 0x74, 0x65, 0x6c, 0x65,
+	0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x2e, 0x44, 0x6e, 0x73, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x30,
+	0x01, 0x42, 0x2e, 0x5a, 0x2c, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f,
+	0x77, 0x69, 0x64, 0x65, 0x2d, 0x76, 0x73, 0x69, 0x78, 0x2f, 0x74, 0x65, 0x6c, 0x65, 0x73, 0x63,
+	0x72, 0x65, 0x65, 0x6e, 0x2f, 0x74, 0x65, 0x6c, 0x65, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x70,
+	0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_telescreenpb_telescreen_proto_rawDescOnce sync.Once
+	file_telescreenpb_telescreen_proto_rawDescData = file_telescreenpb_telescreen_proto_rawDesc
+)
+
+func file_telescreenpb_telescreen_proto_rawDescGZIP() []byte {
+	file_telescreenpb_telescreen_proto_rawDescOnce.Do(func() {
+		file_telescreenpb_telescreen_proto_rawDescData = protoimpl.X.CompressGZIP(file_telescreenpb_telescreen_proto_rawDescData)
+	})
+	return file_telescreenpb_telescreen_proto_rawDescData
+}
+
+var file_telescreenpb_telescreen_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_telescreenpb_telescreen_proto_goTypes = []interface{}{
+	(*Filter)(nil),                // 0: telescreen.Filter
+	(*DnsEvent)(nil),              // 1: telescreen.DnsEvent
+	(*ExtendedError)(nil),         // 2: telescreen.ExtendedError
+	nil,                           // 3: telescreen.DnsEvent.FieldsEntry
+	(*timestamppb.Timestamp)(nil), // 4: google.protobuf.Timestamp
+}
+var file_telescreenpb_telescreen_proto_depIdxs = []int32{
+	4, // 0: telescreen.DnsEvent.received_at:type_name -> google.protobuf.Timestamp
+	3, // 1: telescreen.DnsEvent.fields:type_name -> telescreen.DnsEvent.FieldsEntry
+	2, // 2: telescreen.DnsEvent.extended_errors:type_name -> telescreen.ExtendedError
+	0, // 3: telescreen.Telescreen.Subscribe:input_type -> telescreen.Filter
+	1, // 4: telescreen.Telescreen.Subscribe:output_type -> telescreen.DnsEvent
+	4, // [4:5] is the sub-list for method output_type
+	3, // [3:4] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_telescreenpb_telescreen_proto_init() }
+func file_telescreenpb_telescreen_proto_init() {
+	if File_telescreenpb_telescreen_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_telescreenpb_telescreen_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Filter); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_telescreenpb_telescreen_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DnsEvent); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_telescreenpb_telescreen_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ExtendedError); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_telescreenpb_telescreen_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_telescreenpb_telescreen_proto_goTypes,
+		DependencyIndexes: file_telescreenpb_telescreen_proto_depIdxs,
+		MessageInfos:      file_telescreenpb_telescreen_proto_msgTypes,
+	}.Build()
+	File_telescreenpb_telescreen_proto = out.File
+	file_telescreenpb_telescreen_proto_rawDesc = nil
+	file_telescreenpb_telescreen_proto_goTypes = nil
+	file_telescreenpb_telescreen_proto_depIdxs = nil
+}
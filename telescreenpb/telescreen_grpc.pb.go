@@ -0,0 +1,136 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: telescreenpb/telescreen.proto
+
+package telescreenpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// TelescreenClient is the client API for Telescreen service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TelescreenClient interface {
+	// Subscribe streams events matching the filter until the client goes away.
+	// A subscriber that cannot keep up with the capture is disconnected.
+	Subscribe(ctx context.Context, in *Filter, opts ...grpc.CallOption) (Telescreen_SubscribeClient, error)
+}
+
+type telescreenClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTelescreenClient(cc grpc.ClientConnInterface) TelescreenClient {
+	return &telescreenClient{cc}
+}
+
+func (c *telescreenClient) Subscribe(ctx context.Context, in *Filter, opts ...grpc.CallOption) (Telescreen_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Telescreen_ServiceDesc.Streams[0], "/telescreen.Telescreen/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &telescreenSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Telescreen_SubscribeClient interface {
+	Recv() (*DnsEvent, error)
+	grpc.ClientStream
+}
+
+type telescreenSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *telescreenSubscribeClient) Recv() (*DnsEvent, error) {
+	m := new(DnsEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TelescreenServer is the server API for Telescreen service.
+// All implementations must embed UnimplementedTelescreenServer
+// for forward compatibility
+type TelescreenServer interface {
+	// Subscribe streams events matching the filter until the client goes away.
+	// A subscriber that cannot keep up with the capture is disconnected.
+	Subscribe(*Filter, Telescreen_SubscribeServer) error
+	mustEmbedUnimplementedTelescreenServer()
+}
+
+// UnimplementedTelescreenServer must be embedded to have forward compatible implementations.
+type UnimplementedTelescreenServer struct {
+}
+
+func (UnimplementedTelescreenServer) Subscribe(*Filter, Telescreen_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedTelescreenServer) mustEmbedUnimplementedTelescreenServer() {}
+
+// UnsafeTelescreenServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TelescreenServer will
+// result in compilation errors.
+type UnsafeTelescreenServer interface {
+	mustEmbedUnimplementedTelescreenServer()
+}
+
+func RegisterTelescreenServer(s grpc.ServiceRegistrar, srv TelescreenServer) {
+	s.RegisterService(&Telescreen_ServiceDesc, srv)
+}
+
+func _Telescreen_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Filter)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TelescreenServer).Subscribe(m, &telescreenSubscribeServer{stream})
+}
+
+type Telescreen_SubscribeServer interface {
+	Send(*DnsEvent) error
+	grpc.ServerStream
+}
+
+type telescreenSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *telescreenSubscribeServer) Send(m *DnsEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Telescreen_ServiceDesc is the grpc.ServiceDesc for Telescreen service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Telescreen_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "telescreen.Telescreen",
+	HandlerType: (*TelescreenServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Telescreen_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "telescreenpb/telescreen.proto",
+}